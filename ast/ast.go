@@ -7,9 +7,13 @@ import (
 	"strings"
 )
 
+// Node is implemented by every node of the tree. Pos and End report the
+// source span of the node; End is just past its last character.
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
+	End() token.Position
 }
 
 type Statement interface {
@@ -24,6 +28,10 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+
+	// Comments holds every comment in the source, in order. They are not
+	// part of the tree and only exist for tooling.
+	Comments []*Comment
 }
 
 func (p *Program) String() string {
@@ -43,19 +51,112 @@ func (p *Program) TokenLiteral() string {
 	return p.Statements[0].TokenLiteral()
 }
 
-type LetStatement struct {
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) == 0 {
+		return token.Position{}
+	}
+	return p.Statements[0].Pos()
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) == 0 {
+		return token.Position{}
+	}
+	return p.Statements[len(p.Statements)-1].End()
+}
+
+// after returns the position just past the single character delimiter at p.
+func after(p token.Position) token.Position {
+	p.Offset++
+	p.Column++
+	return p
+}
+
+// posOr returns the start of n, or def if n is missing because of a parse
+// error.
+func posOr(n Node, def token.Position) token.Position {
+	if n == nil {
+		return def
+	}
+	return n.Pos()
+}
+
+// endOr returns the end of n, or def if n is missing because of a parse
+// error.
+func endOr(n Node, def token.Position) token.Position {
+	if n == nil {
+		return def
+	}
+	return n.End()
+}
+
+// Comment is a // line comment or a /* */ block comment. Text includes the
+// comment delimiters.
+type Comment struct {
 	Token token.Token
-	Name  *Identifier
-	Value Expression
+	Text  string
+}
+
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+func (c *Comment) String() string       { return c.Text }
+func (c *Comment) Pos() token.Position  { return c.Token.Pos }
+func (c *Comment) End() token.Position  { return c.Token.End }
+
+// BadStatement is a placeholder for a statement with syntax errors, spanning
+// the source the parser skipped to recover.
+type BadStatement struct {
+	Token token.Token // first token of the statement
+	To    token.Position
+}
+
+func (bs *BadStatement) statementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BadStatement) String() string       { return "<bad statement>" }
+func (bs *BadStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BadStatement) End() token.Position  { return bs.To }
+
+// BadExpression is a placeholder for an expression with syntax errors.
+type BadExpression struct {
+	Token token.Token // first token of the expression
+	To    token.Position
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) String() string       { return "<bad expression>" }
+func (be *BadExpression) Pos() token.Position  { return be.Token.Pos }
+func (be *BadExpression) End() token.Position  { return be.To }
+
+// LetStatement binds Value to Name, or destructures it with Pattern, an
+// *ArrayPattern or *HashPattern, when Name is nil.
+type LetStatement struct {
+	Token   token.Token
+	Name    *Identifier
+	Pattern Expression
+	Value   Expression
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position  { return endOr(ls.Value, ls.Token.End) }
+
+// Names returns the names the statement declares, in order.
+func (ls *LetStatement) Names() []string {
+	if ls.Name != nil {
+		return []string{ls.Name.Value}
+	}
+	return patternNames(ls.Pattern, nil)
+}
 
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Name != nil {
+		out.WriteString(ls.Name.String())
+	} else {
+		out.WriteString(ls.Pattern.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -74,6 +175,8 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 func (i *Identifier) String() string { return i.Value }
 
@@ -84,6 +187,8 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position  { return endOr(rs.ReturnValue, rs.Token.End) }
 
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -104,6 +209,8 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position  { return endOr(es.Expression, es.Token.End) }
 
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
@@ -120,6 +227,19 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -129,6 +249,8 @@ type PrefixExpression struct {
 
 func (p *PrefixExpression) expressionNode()      {}
 func (p *PrefixExpression) TokenLiteral() string { return p.Token.Literal }
+func (p *PrefixExpression) Pos() token.Position  { return p.Token.Pos }
+func (p *PrefixExpression) End() token.Position  { return endOr(p.Right, p.Token.End) }
 func (p *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -149,6 +271,8 @@ type InfixExpression struct {
 
 func (e *InfixExpression) expressionNode()      {}
 func (e *InfixExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *InfixExpression) Pos() token.Position  { return posOr(e.Left, e.Token.Pos) }
+func (e *InfixExpression) End() token.Position  { return endOr(e.Right, e.Token.End) }
 func (e *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -169,6 +293,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type NULL struct {
 	Token token.Token
@@ -177,23 +303,47 @@ type NULL struct {
 func (b *NULL) expressionNode()      {}
 func (b *NULL) TokenLiteral() string { return b.Token.Literal }
 func (b *NULL) String() string       { return b.Token.Literal }
+func (b *NULL) Pos() token.Position  { return b.Token.Pos }
+func (b *NULL) End() token.Position  { return b.Token.End }
 
+// IfExpression is an if with any number of else if branches. Branches are
+// tried in order and Alternative, if any, runs when none of them is taken.
 type IfExpression struct {
-	Token       token.Token
+	Token       token.Token // the first if token
+	Branches    []*IfBranch
+	Alternative *BlockStatement
+}
+
+type IfBranch struct {
+	Token       token.Token // the if token
 	Condition   Expression
 	Consequence *BlockStatement
-	Alternative *BlockStatement
 }
 
 func (e *IfExpression) expressionNode()      {}
 func (e *IfExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *IfExpression) Pos() token.Position  { return e.Token.Pos }
+func (e *IfExpression) End() token.Position {
+	if e.Alternative != nil {
+		return e.Alternative.End()
+	}
+	if n := len(e.Branches); n > 0 && e.Branches[n-1].Consequence != nil {
+		return e.Branches[n-1].Consequence.End()
+	}
+	return e.Token.End
+}
 func (e *IfExpression) String() string {
 	var out bytes.Buffer
 
-	out.WriteString("if")
-	out.WriteString(e.Condition.String())
-	out.WriteString(" ")
-	out.WriteString(e.Consequence.String())
+	for i, b := range e.Branches {
+		if i > 0 {
+			out.WriteString("else ")
+		}
+		out.WriteString("if")
+		out.WriteString(b.Condition.String())
+		out.WriteString(" ")
+		out.WriteString(b.Consequence.String())
+	}
 
 	if e.Alternative != nil {
 		out.WriteString("else ")
@@ -204,12 +354,15 @@ func (e *IfExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // the { token
 	Statements []Statement
+	Rbrace     token.Position
 }
 
 func (s *BlockStatement) statementNode()       {}
 func (s *BlockStatement) TokenLiteral() string { return s.Token.Literal }
+func (s *BlockStatement) Pos() token.Position  { return s.Token.Pos }
+func (s *BlockStatement) End() token.Position  { return after(s.Rbrace) }
 func (s *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -220,43 +373,257 @@ func (s *BlockStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement runs Body for as long as Condition is truthy.
+type WhileStatement struct {
+	Token     token.Token // the while token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	return "while (" + ws.Condition.String() + ") " + ws.Body.String()
+}
+
+// ForStatement is a C style for loop. Init, Condition and Update may each be
+// missing; without a Condition the loop runs until it is left with break or
+// return.
+type ForStatement struct {
+	Token     token.Token // the for token
+	Init      Statement
+	Condition Expression
+	Update    Expression
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Init != nil {
+		out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		out.WriteString(fs.Condition.String())
+	}
+	out.WriteString("; ")
+	if fs.Update != nil {
+		out.WriteString(fs.Update.String())
+	}
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// ForInStatement runs Body once for each element of Iterable. Key is nil when
+// the loop only binds the element's value.
+type ForInStatement struct {
+	Token    token.Token // the for token
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForInStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String() + ", ")
+	}
+	out.WriteString(fs.Value.String() + " in " + fs.Iterable.String() + ") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) String() string       { return "break;" }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStatement
-	Name       string
+	// Defaults holds the default value of each parameter, or nil for a
+	// parameter without one. Only trailing parameters have defaults.
+	Defaults []Expression
+	// Rest, if not nil, is bound to an array of the arguments after
+	// Parameters.
+	Rest *Identifier
+	Body *BlockStatement
+	Name string
+}
+
+// Default returns the default value of parameter i, or nil if it has none.
+func (f *FunctionLiteral) Default(i int) Expression {
+	if i < len(f.Defaults) {
+		return f.Defaults[i]
+	}
+	return nil
 }
 
 func (f *FunctionLiteral) expressionNode()      {}
 func (f *FunctionLiteral) TokenLiteral() string { return f.Token.Literal }
+func (f *FunctionLiteral) Pos() token.Position  { return f.Token.Pos }
+func (f *FunctionLiteral) End() token.Position {
+	if f.Body == nil {
+		return f.Token.End
+	}
+	return f.Body.End()
+}
 func (f *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
-	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
-	}
-
 	out.WriteString(f.TokenLiteral())
 	if f.Name != "" {
 		out.WriteString(fmt.Sprintf("<%s>", f.Name))
 	}
-	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
-	out.WriteString(f.Body.String())
+	out.WriteString(f.signature())
 
 	return out.String()
 }
 
+// signature returns the parameter list and body of f.
+func (f *FunctionLiteral) signature() string {
+	params := []string{}
+	for i, p := range f.Parameters {
+		if d := f.Default(i); d != nil {
+			params = append(params, p.String()+" = "+d.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
+
+	return "(" + strings.Join(params, ", ") + ") " + f.Body.String()
+}
+
+// FunctionStatement declares a named function: fn name(a, b) { ... }. The
+// declaration is hoisted to the start of the enclosing block, so the function
+// can be called before it and functions can call each other in any order.
+type FunctionStatement struct {
+	Token    token.Token // the fn token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *FunctionStatement) End() token.Position  { return fs.Function.End() }
+func (fs *FunctionStatement) String() string {
+	return fs.TokenLiteral() + " " + fs.Name.String() + fs.Function.signature()
+}
+
+// ExportStatement makes the names declared by a let or function statement at
+// the top level of a module visible to the modules that import it.
+type ExportStatement struct {
+	Token     token.Token // the export token
+	Statement Statement   // *LetStatement or *FunctionStatement
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExportStatement) End() token.Position  { return es.Statement.End() }
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+// Names returns the exported names, in the order they are declared.
+func (es *ExportStatement) Names() []string {
+	switch s := es.Statement.(type) {
+	case *LetStatement:
+		return s.Names()
+	case *FunctionStatement:
+		return []string{s.Name.Value}
+	}
+	return nil
+}
+
+func patternNames(pat Expression, names []string) []string {
+	switch pat := pat.(type) {
+	case *Identifier:
+		names = append(names, pat.Value)
+	case *ArrayPattern:
+		for _, el := range pat.Elements {
+			names = patternNames(el, names)
+		}
+		if pat.Rest != nil {
+			names = append(names, pat.Rest.Value)
+		}
+	case *HashPattern:
+		for _, p := range pat.Pairs {
+			names = patternNames(p.Value, names)
+		}
+	}
+	return names
+}
+
+// ImportExpression evaluates to the module at Path, import("./util.is").
+type ImportExpression struct {
+	Token  token.Token // the import token
+	Path   *StringLiteral
+	Rparen token.Position
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *ImportExpression) End() token.Position  { return after(ie.Rparen) }
+func (ie *ImportExpression) String() string {
+	return ie.TokenLiteral() + "(" + ie.Path.String() + ")"
+}
+
 type CallExpression struct {
-	Token     token.Token
+	Token     token.Token // the ( token, or |> for x |> f
 	Function  Expression
 	Arguments []Expression
+	// Rparen is not valid for x |> f, which has no parentheses.
+	Rparen token.Position
 }
 
 func (c *CallExpression) expressionNode()      {}
 func (c *CallExpression) TokenLiteral() string { return c.Token.Literal }
+func (c *CallExpression) Pos() token.Position  { return posOr(c.Function, c.Token.Pos) }
+func (c *CallExpression) End() token.Position {
+	if !c.Rparen.IsValid() {
+		return endOr(c.Function, c.Token.End)
+	}
+	return after(c.Rparen)
+}
 func (c *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -273,6 +640,19 @@ func (c *CallExpression) String() string {
 	return out.String()
 }
 
+// SpreadExpression passes the elements of an array as separate arguments of a
+// call: f(...args).
+type SpreadExpression struct {
+	Token token.Token // the ... token
+	Value Expression
+}
+
+func (s *SpreadExpression) expressionNode()      {}
+func (s *SpreadExpression) TokenLiteral() string { return s.Token.Literal }
+func (s *SpreadExpression) String() string       { return "..." + s.Value.String() }
+func (s *SpreadExpression) Pos() token.Position  { return s.Token.Pos }
+func (s *SpreadExpression) End() token.Position  { return endOr(s.Value, s.Token.End) }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -281,14 +661,52 @@ type StringLiteral struct {
 func (s *StringLiteral) expressionNode()      {}
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 func (s *StringLiteral) String() string       { return s.Token.Literal }
+func (s *StringLiteral) Pos() token.Position  { return s.Token.Pos }
+func (s *StringLiteral) End() token.Position  { return s.Token.End }
+
+// InterpolatedString is a string containing ${expr} interpolations. Parts
+// alternates between text, as *StringLiteral, and the interpolated
+// expressions, so it always starts and ends with a possibly empty text part.
+type InterpolatedString struct {
+	Token token.Token // the STRING_HEAD token
+	Parts []Expression
+}
+
+func (s *InterpolatedString) expressionNode()      {}
+func (s *InterpolatedString) TokenLiteral() string { return s.Token.Literal }
+func (s *InterpolatedString) Pos() token.Position  { return s.Token.Pos }
+func (s *InterpolatedString) End() token.Position {
+	if len(s.Parts) == 0 {
+		return s.Token.End
+	}
+	return endOr(s.Parts[len(s.Parts)-1], s.Token.End)
+}
+func (s *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	for i, part := range s.Parts {
+		if i%2 == 0 {
+			out.WriteString(part.String())
+			continue
+		}
+		out.WriteString("${")
+		out.WriteString(part.String())
+		out.WriteString("}")
+	}
+
+	return out.String()
+}
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // the [ token
 	Elements []Expression
+	Rbracket token.Position
 }
 
 func (a *ArrayLiteral) expressionNode()      {}
 func (a *ArrayLiteral) TokenLiteral() string { return a.Token.Literal }
+func (a *ArrayLiteral) Pos() token.Position  { return a.Token.Pos }
+func (a *ArrayLiteral) End() token.Position  { return after(a.Rbracket) }
 func (a *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -304,14 +722,184 @@ func (a *ArrayLiteral) String() string {
 	return out.String()
 }
 
-type IndexExpression struct {
-	Token token.Token // the [ token
+// ArrayPattern destructures an array. Each element is bound to an
+// *Identifier, or destructured further by a nested pattern. In a match arm an
+// element may also be a literal or a *WildcardPattern. Rest, if not nil, is
+// bound to an array of the elements after them.
+type ArrayPattern struct {
+	Token    token.Token // the [ token
+	Elements []Expression
+	Rest     *Identifier
+	Rbracket token.Position
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position  { return after(ap.Rbracket) }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern destructures a hash by key. {name} is short for {name: name}.
+type HashPattern struct {
+	Token  token.Token // the { token
+	Pairs  []HashPatternPair
+	Rbrace token.Position
+}
+
+type HashPatternPair struct {
+	Key   *StringLiteral
+	Value Expression // *Identifier or a nested pattern
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position  { return after(hp.Rbrace) }
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, p := range hp.Pairs {
+		if id, ok := p.Value.(*Identifier); ok && id.Value == p.Key.Value {
+			pairs = append(pairs, id.String())
+			continue
+		}
+		pairs = append(pairs, p.Key.String()+": "+p.Value.String())
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// WildcardPattern is the _ pattern of a match arm, which matches anything.
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) expressionNode()      {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) Pos() token.Position  { return wp.Token.Pos }
+func (wp *WildcardPattern) End() token.Position  { return wp.Token.End }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// MatchExpression evaluates to the body of the first arm whose pattern
+// matches Subject and whose guard, if any, is truthy. It is null when no arm
+// is taken.
+type MatchExpression struct {
+	Token   token.Token // the match token
+	Subject Expression
+	Arms    []*MatchArm
+	Rbrace  token.Position
+}
+
+// MatchArm is one pattern => body arm.
+type MatchArm struct {
+	Pattern Expression
+	Guard   Expression
+	Body    Node // *BlockStatement or an Expression
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) End() token.Position  { return after(me.Rbrace) }
+func (me *MatchExpression) String() string {
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+
+	return "match " + me.Subject.String() + " {" + strings.Join(arms, ", ") + "}"
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// SliceExpression is left[low:high]. Low and High are nil when left out.
+type SliceExpression struct {
+	Token    token.Token // the [ token
+	Left     Expression
+	Low      Expression
+	High     Expression
+	Rbracket token.Position
+}
+
+func (s *SliceExpression) expressionNode()      {}
+func (s *SliceExpression) TokenLiteral() string { return s.Token.Literal }
+func (s *SliceExpression) Pos() token.Position  { return posOr(s.Left, s.Token.Pos) }
+func (s *SliceExpression) End() token.Position  { return after(s.Rbracket) }
+func (s *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(s.Left.String())
+	out.WriteString("[")
+	if s.Low != nil {
+		out.WriteString(s.Low.String())
+	}
+	out.WriteString(":")
+	if s.High != nil {
+		out.WriteString(s.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// MemberExpression is left.name. It reads the field name of a hash, or the
+// method name of any value.
+type MemberExpression struct {
+	Token token.Token // the . token
 	Left  Expression
-	Index Expression
+	Name  *Identifier
+}
+
+func (m *MemberExpression) expressionNode()      {}
+func (m *MemberExpression) TokenLiteral() string { return m.Token.Literal }
+func (m *MemberExpression) Pos() token.Position  { return posOr(m.Left, m.Token.Pos) }
+func (m *MemberExpression) End() token.Position  { return endOr(m.Name, m.Token.End) }
+func (m *MemberExpression) String() string {
+	return "(" + m.Left.String() + "." + m.Name.String() + ")"
+}
+
+// AsIndex returns left["name"], which assigning to m is short for.
+func (m *MemberExpression) AsIndex() *IndexExpression {
+	return &IndexExpression{
+		Token:    m.Token,
+		Left:     m.Left,
+		Index:    &StringLiteral{Token: m.Name.Token, Value: m.Name.Value},
+		Rbracket: m.Name.Token.Pos,
+	}
+}
+
+type IndexExpression struct {
+	Token    token.Token // the [ token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position
 }
 
 func (i *IndexExpression) expressionNode()      {}
 func (i *IndexExpression) TokenLiteral() string { return i.Token.Literal }
+func (i *IndexExpression) Pos() token.Position  { return posOr(i.Left, i.Token.Pos) }
+func (i *IndexExpression) End() token.Position  { return after(i.Rbracket) }
 func (i *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -324,12 +912,15 @@ func (i *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // the { token
+	Pairs  map[Expression]Expression
+	Rbrace token.Position
 }
 
 func (h *HashLiteral) expressionNode()      {}
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
+func (h *HashLiteral) Pos() token.Position  { return h.Token.Pos }
+func (h *HashLiteral) End() token.Position  { return after(h.Rbrace) }
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -344,3 +935,30 @@ func (h *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// AssignExpression changes the value of an existing variable or of an array or
+// hash element: x = 5, arr[0] = 5, h.key = 5. A compound assignment such as
+// x += 5 has the operator applied, "+".
+type AssignExpression struct {
+	Token    token.Token // the = or compound assignment token
+	Target   Expression  // *Identifier, *IndexExpression or *MemberExpression
+	Operator string      // empty for plain =
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position  { return endOr(ae.Value, ae.Token.End) }
+
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Token.Literal + " ")
+	if ae.Value != nil {
+		out.WriteString(ae.Value.String())
+	}
+	out.WriteString(")")
+	return out.String()
+}
@@ -0,0 +1,144 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Inspect traverses the tree rooted at node in source order, calling f for
+// each node. The children of a node are visited only if f returns true for it.
+// Missing parts of a node, such as the condition of for (;;), are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		inspectStatements(n.Statements, f)
+	case *BlockStatement:
+		inspectStatements(n.Statements, f)
+	case *LetStatement:
+		if n.Name != nil {
+			Inspect(n.Name, f)
+		}
+		Inspect(n.Pattern, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *ExportStatement:
+		Inspect(n.Statement, f)
+	case *FunctionStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Function, f)
+	case *WhileStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *ForStatement:
+		Inspect(n.Init, f)
+		Inspect(n.Condition, f)
+		Inspect(n.Update, f)
+		Inspect(n.Body, f)
+	case *ForInStatement:
+		if n.Key != nil {
+			Inspect(n.Key, f)
+		}
+		Inspect(n.Value, f)
+		Inspect(n.Iterable, f)
+		Inspect(n.Body, f)
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *AssignExpression:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *IfExpression:
+		for _, b := range n.Branches {
+			Inspect(b.Condition, f)
+			Inspect(b.Consequence, f)
+		}
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *MatchExpression:
+		Inspect(n.Subject, f)
+		for _, arm := range n.Arms {
+			Inspect(arm.Pattern, f)
+			Inspect(arm.Guard, f)
+			Inspect(arm.Body, f)
+		}
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			Inspect(p, f)
+			Inspect(n.Default(i), f)
+		}
+		if n.Rest != nil {
+			Inspect(n.Rest, f)
+		}
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		inspectExpressions(n.Arguments, f)
+	case *SpreadExpression:
+		Inspect(n.Value, f)
+	case *ImportExpression:
+		Inspect(n.Path, f)
+	case *InterpolatedString:
+		inspectExpressions(n.Parts, f)
+	case *ArrayLiteral:
+		inspectExpressions(n.Elements, f)
+	case *ArrayPattern:
+		inspectExpressions(n.Elements, f)
+		if n.Rest != nil {
+			Inspect(n.Rest, f)
+		}
+	case *HashLiteral:
+		// Pairs is a map, visit the pairs in the order they were written.
+		keys := make([]Expression, 0, len(n.Pairs))
+		for k := range n.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Pos().Offset < keys[j].Pos().Offset })
+		for _, k := range keys {
+			Inspect(k, f)
+			Inspect(n.Pairs[k], f)
+		}
+	case *HashPattern:
+		for _, p := range n.Pairs {
+			Inspect(p.Key, f)
+			Inspect(p.Value, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *SliceExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Low, f)
+		Inspect(n.High, f)
+	case *MemberExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Name, f)
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *Boolean,
+		*NULL, *WildcardPattern, *BreakStatement, *ContinueStatement,
+		*BadStatement, *BadExpression:
+		// Leaves.
+	default:
+		panic(fmt.Sprintf("ast.Inspect: unexpected node type %T", n))
+	}
+}
+
+func inspectStatements(stmts []Statement, f func(Node) bool) {
+	for _, s := range stmts {
+		Inspect(s, f)
+	}
+}
+
+func inspectExpressions(exprs []Expression, f func(Node) bool) {
+	for _, e := range exprs {
+		Inspect(e, f)
+	}
+}
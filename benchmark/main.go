@@ -23,7 +23,7 @@ let memo = fn(f, x) {
 		return cache[x];
 	};
 	let c = f(x);
-	updateHash(cache, x, c);
+	cache[x] = c;
 	return c;
 };
 let fib = fn(x) {
@@ -41,6 +41,34 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpConcat
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpBitNot
+	OpSetFree
+	OpCaptureLocal
+	OpCaptureFree
+	OpSetIndex
+	OpDup
+	OpIterInit
+	OpIterNext
+	OpCheckArray
+	OpCheckHash
+	OpSlice
+	OpCallSpread
+	OpMatchArray
+	OpMatchHash
+	OpMatchValue
+	OpMember
+	OpImport
 )
 
 var definitions = map[Opcode]*Definition{
@@ -74,6 +102,34 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 1}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpConcat:         {"OpConcat", []int{2}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpLessEqual:      {"OpLessEqual", []int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpPow:            {"OpPow", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpShl:            {"OpShl", []int{}},
+	OpShr:            {"OpShr", []int{}},
+	OpBitNot:         {"OpBitNot", []int{}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpDup:            {"OpDup", []int{1}},
+	OpIterInit:       {"OpIterInit", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2, 1}},
+	OpCheckArray:     {"OpCheckArray", []int{2, 1}},
+	OpCheckHash:      {"OpCheckHash", []int{1}},
+	OpSlice:          {"OpSlice", []int{}},
+	OpCallSpread:     {"OpCallSpread", []int{1}},
+	OpMatchArray:     {"OpMatchArray", []int{2, 1}},
+	OpMatchHash:      {"OpMatchHash", []int{1}},
+	OpMatchValue:     {"OpMatchValue", []int{}},
+	OpMember:         {"OpMember", []int{2}},
+	OpImport:         {"OpImport", []int{2}},
 }
 
 type Definition struct {
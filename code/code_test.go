@@ -12,6 +12,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpIterNext, []int{513, 2}, []byte{byte(OpIterNext), 2, 1, 2}},
 	}
 
 	for _, tt := range tests {
@@ -1,11 +1,17 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"iscript/ast"
 	"iscript/code"
+	"iscript/lexer"
+	"iscript/module"
 	"iscript/object"
+	"iscript/parser"
+	"iscript/token"
 	"sort"
+	"strings"
 )
 
 type Compiler struct {
@@ -15,8 +21,40 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	warnings []string
+
+	// path is the path of the module being compiled, "" for the main
+	// program, and exports maps the names it exports to their globals.
+	path    string
+	exports map[string]int
+	modules *Modules
+}
+
+// Modules holds the modules imported by a program. It is shared by the
+// compilers of the program and of all the modules it imports, and by the
+// compilers of the lines of a REPL session.
+type Modules struct {
+	loader   module.Loader
+	registry *module.Registry
+	// compiled maps the path of each compiled module to its constant, and
+	// natives the name of each imported module from the registry.
+	compiled map[string]int
+	natives  map[string]int
+	// loading holds the modules being compiled, each imported by the one
+	// before it.
+	loading []string
 }
 
+// ModuleError is an error in a module imported by the program being compiled.
+type ModuleError struct {
+	Path string
+	Err  error
+}
+
+func (e *ModuleError) Error() string { return e.Path + ": " + e.Err.Error() }
+func (e *ModuleError) Unwrap() error { return e.Err }
+
 type EmittedInstruction struct {
 	Opcode   code.Opcode
 	Position int
@@ -26,6 +64,20 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	// loops holds the loops being compiled in this scope, innermost last.
+	loops []*loop
+	// held counts the values on the stack that the expressions being
+	// compiled will use once the one being compiled is done.
+	held int
+}
+
+// loop records the jumps emitted by break and continue statements until the
+// positions they jump to are known.
+type loop struct {
+	breaks    []int
+	continues []int
+	// held is the number of values held on the stack when the loop starts.
+	held int
 }
 
 func NewWithState(s *SymTable, constants []object.Object) *Compiler {
@@ -53,18 +105,73 @@ func New() *Compiler {
 		symTable:   symTable,
 		scopes:     []CompilationScope{mainScope},
 		scopeIndex: 0,
+		exports:    map[string]int{},
 	}
 }
 
+// SetLoader makes the compiler load the modules the program imports with l.
+// Without a loader, importing a file is an error.
+func (c *Compiler) SetLoader(l module.Loader) {
+	c.moduleSet().loader = l
+}
+
+// SetRegistry makes the modules in r importable by the program.
+func (c *Compiler) SetRegistry(r *module.Registry) {
+	c.moduleSet().registry = r
+}
+
+// SetModules makes the compiler share m with the compilers that used it
+// before, so a module they imported is not compiled again. The constants of
+// those compilers must be passed on to this one.
+func (c *Compiler) SetModules(m *Modules) {
+	c.modules = m
+}
+
+// NewModules returns an empty set of modules, loaded with l and looked up in
+// r. Either can be nil.
+func NewModules(l module.Loader, r *module.Registry) *Modules {
+	return &Modules{loader: l, registry: r, compiled: map[string]int{}, natives: map[string]int{}}
+}
+
+func (c *Compiler) moduleSet() *Modules {
+	if c.modules == nil {
+		c.modules = NewModules(nil, nil)
+	}
+	return c.modules
+}
+
+var infixOps = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShl,
+	">>": code.OpShr,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		return c.compileProgram(node)
+	case *ast.ExportStatement:
+		return fmt.Errorf("%s: export outside the top level of a module", node.Pos())
+	case *ast.ImportExpression:
+		idx, err := c.compileModule(node)
+		if err != nil {
+			return err
 		}
+		c.emit(code.OpImport, idx)
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
 		if err != nil {
@@ -72,44 +179,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			c.emit(code.OpGreaterThan)
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
 		}
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
 		}
+		c.hold(1)
 		err = c.Compile(node.Right)
 		if err != nil {
 			return err
 		}
-		switch node.Operator {
-		case "+":
-			c.emit(code.OpAdd)
-		case "-":
-			c.emit(code.OpSub)
-		case "*":
-			c.emit(code.OpMul)
-		case "/":
-			c.emit(code.OpDiv)
-		case ">":
-			c.emit(code.OpGreaterThan)
-		case "==":
-			c.emit(code.OpEqual)
-		case "!=":
-			c.emit(code.OpNotEqual)
-		default:
+		c.hold(-1)
+		op, ok := infixOps[node.Operator]
+		if !ok {
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
+		c.emit(op)
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
@@ -121,12 +208,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
 			return fmt.Errorf("unknown operator: %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.NULL:
 		c.emit(code.OpNull)
 	case *ast.Boolean:
@@ -136,53 +228,141 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.IfExpression:
+		// Every branch that is taken jumps past the whole chain, patched
+		// once its end is known.
+		var jmpPos []int
+		for _, b := range node.Branches {
+			err := c.Compile(b.Condition)
+			if err != nil {
+				return err
+			}
+
+			// Jump Not Truthy with bogus
+			jntPos := c.emit(code.OpJNT, 9999)
+			err = c.compileBranch(b.Consequence)
+			if err != nil {
+				return err
+			}
+
+			jmpPos = append(jmpPos, c.emit(code.OpJmp, 9999))
+
+			afterConsequencePos := len(c.currentInstructions())
+			c.changeOperand(jntPos, afterConsequencePos)
+		}
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			err := c.compileBranch(node.Alternative)
+			if err != nil {
+				return err
+			}
+		}
+		afterAlternativePos := len(c.currentInstructions())
+		for _, pos := range jmpPos {
+			c.changeOperand(pos, afterAlternativePos)
+		}
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+	case *ast.BlockStatement:
+		return c.compileStatements(node.Statements)
+	case *ast.FunctionStatement:
+		sym, ok := c.symTable.Resolve(node.Name.Value)
+		if !ok {
+			sym = c.symTable.Define(node.Name.Value)
+		}
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+		c.storeSymbol(sym)
+	case *ast.WhileStatement:
+		start := len(c.currentInstructions())
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
+		exit := c.emit(code.OpJNT, 9999)
 
-		// Jump Not Truthy with bogus
-		jntPos := c.emit(code.OpJNT, 9999)
-		err = c.Compile(node.Consequence)
+		c.enterLoop()
+		err = c.Compile(node.Body)
 		if err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
+		c.emit(code.OpJmp, start)
+
+		c.changeOperand(exit, len(c.currentInstructions()))
+		c.leaveLoop(start, len(c.currentInstructions()))
+		c.emitLoopValue()
+	case *ast.ForStatement:
+		if node.Init != nil {
+			err := c.Compile(node.Init)
+			if err != nil {
+				return err
+			}
 		}
 
-		jmpPos := c.emit(code.OpJmp, 9999)
+		start := len(c.currentInstructions())
+		exit := -1
+		if node.Condition != nil {
+			err := c.Compile(node.Condition)
+			if err != nil {
+				return err
+			}
+			exit = c.emit(code.OpJNT, 9999)
+		}
 
-		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jntPos, afterConsequencePos)
+		c.enterLoop()
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
 
-		if node.Alternative == nil {
-			c.emit(code.OpNull)
-		} else {
-			err = c.Compile(node.Alternative)
+		update := len(c.currentInstructions())
+		if node.Update != nil {
+			err := c.Compile(node.Update)
 			if err != nil {
 				return err
 			}
+			c.emit(code.OpPop)
+		}
+		c.emit(code.OpJmp, start)
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+		if exit >= 0 {
+			c.changeOperand(exit, len(c.currentInstructions()))
 		}
-		afterAltenrativePos := len(c.currentInstructions())
-		c.changeOperand(jmpPos, afterAltenrativePos)
-	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
+		c.leaveLoop(update, len(c.currentInstructions()))
+		c.emitLoopValue()
+	case *ast.ForInStatement:
+		return c.compileForIn(node)
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("%s: break outside loop", node.Pos())
+		}
+		c.unwind(l)
+		l.breaks = append(l.breaks, c.emit(code.OpJmp, 9999))
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("%s: continue outside loop", node.Pos())
+		}
+		c.unwind(l)
+		l.continues = append(l.continues, c.emit(code.OpJmp, 9999))
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			err := c.Compile(node.Value)
 			if err != nil {
 				return err
 			}
+			return c.compilePattern(node.Pattern)
 		}
-	case *ast.LetStatement:
-		sym := c.symTable.Define(node.Name.Value)
+
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
+		sym := c.symTable.Declare(node.Name.Value)
 		scp := code.OpSetLocal
 		if sym.Scope == GlobalScope {
 			scp = code.OpSetGlobal
@@ -191,19 +371,38 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		sym, ok := c.symTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable: %s", node.Value)
+			return fmt.Errorf("%s: undefined variable: %s", node.Pos(), node.Value)
 		}
 		c.loadSymbol(sym)
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
+	case *ast.InterpolatedString:
+		numParts := 0
+		for i, part := range node.Parts {
+			if lit, ok := part.(*ast.StringLiteral); ok && i%2 == 0 && lit.Value == "" {
+				continue
+			}
+			err := c.Compile(part)
+			if err != nil {
+				return err
+			}
+			c.hold(1)
+			numParts++
+		}
+		c.hold(-numParts)
+		c.emit(code.OpConcat, numParts)
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			err := c.Compile(el)
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 		}
+		c.hold(-len(node.Elements))
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
 		keys := []ast.Expression{}
@@ -219,31 +418,93 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 			err = c.Compile(node.Pairs[k])
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 		}
+		c.hold(-len(node.Pairs) * 2)
 		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
 		}
+		c.hold(1)
 		err = c.Compile(node.Index)
 		if err != nil {
 			return err
 		}
+		c.hold(-1)
 		c.emit(code.OpIndex)
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		c.hold(1)
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound != nil {
+				err := c.Compile(bound)
+				if err != nil {
+					return err
+				}
+			} else {
+				c.emit(code.OpNull)
+			}
+			c.hold(1)
+		}
+		c.hold(-3)
+		c.emit(code.OpSlice)
+	case *ast.MemberExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpMember, c.addConstant(&object.String{Value: node.Name.Value}))
 	case *ast.FunctionLiteral:
 		c.enterScope()
 
-		if node.Name != "" {
+		// A function that assigns to its own name refers to the variable
+		// instead, so it sees the new value like the evaluator does.
+		if node.Name != "" && !assignsTo(node, node.Name) {
 			c.symTable.DefineFunctionName(node.Name)
 		}
 
-		for _, p := range node.Parameters {
-			c.symTable.Define(p.Value)
+		// The parameters get the first locals, but each one only becomes
+		// visible after its default, so a default sees the parameters before
+		// it and the enclosing scope, never the body's locals.
+		c.symTable.numDefinitions = len(node.Parameters)
+		if node.Rest != nil {
+			c.symTable.numDefinitions++
+		}
+
+		minArity := len(node.Parameters)
+		var defaults []int
+		skipDefaults := -1
+		for i, p := range node.Parameters {
+			if def := node.Default(i); def != nil {
+				if defaults == nil {
+					minArity = i
+					skipDefaults = c.emit(code.OpJmp, 9999)
+				}
+				defaults = append(defaults, len(c.currentInstructions()))
+				err := c.Compile(def)
+				if err != nil {
+					return err
+				}
+				c.storeSymbol(c.symTable.defineParam(p.Value, i))
+				continue
+			}
+			c.symTable.defineParam(p.Value, i)
+		}
+		if node.Rest != nil {
+			c.symTable.defineParam(node.Rest.Value, len(node.Parameters))
+		}
+		if skipDefaults >= 0 {
+			c.changeOperand(skipDefaults, len(c.currentInstructions()))
 		}
 
 		err := c.Compile(node.Body)
@@ -251,7 +512,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
+		if endsInExpression(node.Body) {
 			c.replaceLastPopWithReturn()
 		}
 
@@ -259,18 +520,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpReturn)
 		}
 
+		maxArity := len(node.Parameters)
+		if node.Rest != nil {
+			maxArity = -1
+		}
+
 		freeSyms := c.symTable.FreeSyms
 		numLocals := c.symTable.numDefinitions
 		instructions := c.leaveScope()
 
 		for _, s := range freeSyms {
-			c.loadSymbol(s)
+			c.captureSymbol(s)
 		}
 
 		compiledFn := &object.CompiledFunc{
 			Instructions: instructions,
 			NumLocals:    numLocals,
 			NumParams:    len(node.Parameters),
+			MinArity:     minArity,
+			MaxArity:     maxArity,
+			Defaults:     defaults,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSyms))
@@ -286,19 +555,644 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		c.hold(1)
+		defer c.hold(-1)
+
+		if hasSpread(node.Arguments) {
+			return c.compileSpreadCall(node)
+		}
 
 		for _, a := range node.Arguments {
 			err := c.Compile(a)
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 		}
+		c.hold(-len(node.Arguments))
 
 		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.SpreadExpression:
+		return fmt.Errorf("%s: cannot spread outside of call arguments", node.Pos())
+	case *ast.BadStatement, *ast.BadExpression:
+		return fmt.Errorf("%s: cannot compile code with syntax errors", node.Pos())
+	}
+	return nil
+}
+
+// compileAssign compiles an assignment, leaving the assigned value on the
+// stack. The target of a compound assignment is evaluated only once.
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	compileValue := func() error {
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if node.Operator == "" {
+			return nil
+		}
+		op, ok := infixOps[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+		return nil
+	}
+
+	target := node.Target
+	if m, ok := target.(*ast.MemberExpression); ok {
+		target = m.AsIndex()
+	}
+
+	switch target := target.(type) {
+	case *ast.Identifier:
+		sym, ok := c.symTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: assignment to undeclared variable: %s", node.Pos(), target.Value)
+		}
+		if !c.assignable(sym) {
+			return fmt.Errorf("%s: cannot assign to %s", node.Pos(), target.Value)
+		}
+
+		if node.Operator != "" {
+			c.loadSymbol(sym)
+			c.hold(1)
+			defer c.hold(-1)
+		}
+		err := compileValue()
+		if err != nil {
+			return err
+		}
+		c.storeSymbol(sym)
+		c.loadSymbol(sym)
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		c.hold(1)
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		c.hold(1)
+
+		if node.Operator != "" {
+			c.emit(code.OpDup, 2)
+			c.emit(code.OpIndex)
+			c.hold(1)
+		}
+		err = compileValue()
+		if err != nil {
+			return err
+		}
+		c.hold(-2)
+		if node.Operator != "" {
+			c.hold(-1)
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target)
+	}
+
+	return nil
+}
+
+// compileLogical compiles && and || so that the right operand is only
+// evaluated when the left one does not already decide the result. Either way
+// the result is a boolean.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	var toFalse []int
+	if node.Operator == "&&" {
+		toFalse = append(toFalse, c.emit(code.OpJNT, 9999))
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		toFalse = append(toFalse, c.emit(code.OpJNT, 9999))
+	} else {
+		toRight := c.emit(code.OpJNT, 9999)
+		toTrue := c.emit(code.OpJmp, 9999)
+		c.changeOperand(toRight, len(c.currentInstructions()))
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		toFalse = append(toFalse, c.emit(code.OpJNT, 9999))
+		c.changeOperand(toTrue, len(c.currentInstructions()))
+	}
+
+	c.emit(code.OpTrue)
+	end := c.emit(code.OpJmp, 9999)
+	for _, pos := range toFalse {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.emit(code.OpFalse)
+	c.changeOperand(end, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileForIn compiles a for-in loop. The iterator stays on the stack while
+// the loop runs, and is popped by the instruction both the end of iteration
+// and break statements jump to.
+func (c *Compiler) compileForIn(node *ast.ForInStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIterInit)
+	c.hold(1)
+	defer c.hold(-1)
+
+	var vars []Sym
+	if node.Key != nil {
+		vars = append(vars, c.symTable.Define(node.Key.Value))
+	}
+	vars = append(vars, c.symTable.Define(node.Value.Value))
+
+	start := c.emit(code.OpIterNext, 9999, len(vars))
+	for i := len(vars) - 1; i >= 0; i-- {
+		c.storeSymbol(vars[i])
+	}
+
+	c.enterLoop()
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
 	}
+	c.emit(code.OpJmp, start)
+
+	exit := c.emit(code.OpPop)
+	c.changeOperand(start, exit, len(vars))
+	c.leaveLoop(start, exit)
+	c.emitLoopValue()
+
 	return nil
 }
 
+// compilePattern binds the names in pat to the parts of the value on top of
+// the stack, and pops it. The names are defined only after the value is
+// compiled, so let [a, b] = [b, a] swaps a and b.
+func (c *Compiler) compilePattern(pat ast.Expression) error {
+	switch pat := pat.(type) {
+	case *ast.Identifier:
+		c.storeSymbol(c.symTable.Declare(pat.Value))
+	case *ast.ArrayPattern:
+		hasRest := 0
+		if pat.Rest != nil {
+			hasRest = 1
+		}
+		c.emit(code.OpCheckArray, len(pat.Elements), hasRest)
+
+		for i, el := range pat.Elements {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+			err := c.compilePattern(el)
+			if err != nil {
+				return err
+			}
+		}
+
+		if pat.Rest != nil {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(len(pat.Elements))}))
+			c.emit(code.OpNull)
+			c.emit(code.OpSlice)
+			c.storeSymbol(c.symTable.Declare(pat.Rest.Value))
+		}
+		c.emit(code.OpPop)
+	case *ast.HashPattern:
+		for _, p := range pat.Pairs {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: p.Key.Value}))
+		}
+		c.emit(code.OpCheckHash, len(pat.Pairs))
+
+		for _, p := range pat.Pairs {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: p.Key.Value}))
+			c.emit(code.OpIndex)
+			err := c.compilePattern(p.Value)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPop)
+	default:
+		return fmt.Errorf("%s: cannot destructure into %s", pat.Pos(), pat)
+	}
+	return nil
+}
+
+// failJumps holds the jumps taken when a match arm fails, by the number of
+// values they leave on the stack above the subject.
+type failJumps [][]int
+
+func (f *failJumps) add(depth, pos int) {
+	for len(*f) <= depth {
+		*f = append(*f, nil)
+	}
+	(*f)[depth] = append((*f)[depth], pos)
+}
+
+// compileMatch compiles a match expression. The subject stays on the stack
+// while each arm tests a copy of it. Arrays and hashes being looked into are
+// kept on the stack too, so a failed test jumps into a run of OpPops that
+// clears however many are left before the next arm.
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+	c.hold(1)
+
+	var endJumps []int
+	var catchAll ast.Expression
+	for _, arm := range node.Arms {
+		if catchAll != nil {
+			c.warnf(arm.Pattern.Pos(), "unreachable match arm, %s at %s matches everything", catchAll, catchAll.Pos())
+		} else if arm.Guard == nil && irrefutable(arm.Pattern) {
+			catchAll = arm.Pattern
+		}
+
+		// The names bound by the arm are only visible in it.
+		c.symTable = NewBlockSymTable(c.symTable)
+
+		var fails failJumps
+		c.emit(code.OpDup, 1)
+		err := c.compileMatchPattern(arm.Pattern, 1, &fails)
+		if err != nil {
+			return err
+		}
+
+		if arm.Guard != nil {
+			err := c.Compile(arm.Guard)
+			if err != nil {
+				return err
+			}
+			fails.add(0, c.emit(code.OpJNT, 9999))
+		}
+
+		c.emit(code.OpPop)
+		c.hold(-1)
+		if block, ok := arm.Body.(*ast.BlockStatement); ok {
+			err = c.compileBranch(block)
+		} else {
+			err = c.Compile(arm.Body)
+		}
+		if err != nil {
+			return err
+		}
+		c.hold(1)
+		c.symTable = c.symTable.Outer
+		endJumps = append(endJumps, c.emit(code.OpJmp, 9999))
+
+		for depth := len(fails) - 1; depth >= 0; depth-- {
+			for _, pos := range fails[depth] {
+				c.changeOperand(pos, len(c.currentInstructions()))
+			}
+			if depth > 0 {
+				c.emit(code.OpPop)
+			}
+		}
+	}
+
+	c.emit(code.OpPop)
+	c.hold(-1)
+	c.emit(code.OpNull)
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileMatchPattern tests the value on top of the stack against pat, which
+// consumes it. depth is the number of values above the subject, counting it.
+func (c *Compiler) compileMatchPattern(pat ast.Expression, depth int, fails *failJumps) error {
+	switch pat := pat.(type) {
+	case *ast.WildcardPattern:
+		c.emit(code.OpPop)
+	case *ast.Identifier:
+		c.storeSymbol(c.symTable.Define(pat.Value))
+	case *ast.ArrayPattern:
+		hasRest := 0
+		if pat.Rest != nil {
+			hasRest = 1
+		}
+		c.emit(code.OpDup, 1)
+		c.emit(code.OpMatchArray, len(pat.Elements), hasRest)
+		fails.add(depth, c.emit(code.OpJNT, 9999))
+
+		for i, el := range pat.Elements {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+			err := c.compileMatchPattern(el, depth+1, fails)
+			if err != nil {
+				return err
+			}
+		}
+
+		if pat.Rest != nil {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(len(pat.Elements))}))
+			c.emit(code.OpNull)
+			c.emit(code.OpSlice)
+			c.storeSymbol(c.symTable.Define(pat.Rest.Value))
+		}
+		c.emit(code.OpPop)
+	case *ast.HashPattern:
+		c.emit(code.OpDup, 1)
+		for _, p := range pat.Pairs {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: p.Key.Value}))
+		}
+		c.emit(code.OpMatchHash, len(pat.Pairs))
+		fails.add(depth, c.emit(code.OpJNT, 9999))
+
+		for _, p := range pat.Pairs {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: p.Key.Value}))
+			c.emit(code.OpIndex)
+			err := c.compileMatchPattern(p.Value, depth+1, fails)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPop)
+	default:
+		err := c.Compile(pat)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpMatchValue)
+		fails.add(depth-1, c.emit(code.OpJNT, 9999))
+	}
+	return nil
+}
+
+// irrefutable reports whether pat matches every value.
+func irrefutable(pat ast.Expression) bool {
+	switch pat.(type) {
+	case *ast.WildcardPattern, *ast.Identifier:
+		return true
+	}
+	return false
+}
+
+func hasSpread(args []ast.Expression) bool {
+	for _, a := range args {
+		if _, ok := a.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSpreadCall compiles a call spreading arrays into its arguments. Each
+// argument is pushed as an array of the values it passes, which OpCallSpread
+// joins.
+func (c *Compiler) compileSpreadCall(node *ast.CallExpression) error {
+	for _, a := range node.Arguments {
+		if spread, ok := a.(*ast.SpreadExpression); ok {
+			err := c.Compile(spread.Value)
+			if err != nil {
+				return err
+			}
+			c.hold(1)
+			continue
+		}
+
+		err := c.Compile(a)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpArray, 1)
+		c.hold(1)
+	}
+	c.hold(-len(node.Arguments))
+
+	c.emit(code.OpCallSpread, len(node.Arguments))
+	return nil
+}
+
+// compileProgram compiles the top level of the main program or of a module,
+// recording the globals that hold the names it exports.
+func (c *Compiler) compileProgram(p *ast.Program) error {
+	stmts := make([]ast.Statement, len(p.Statements))
+	for i, s := range p.Statements {
+		if es, ok := s.(*ast.ExportStatement); ok {
+			s = es.Statement
+		}
+		stmts[i] = s
+	}
+	err := c.compileStatements(stmts)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range p.Statements {
+		es, ok := s.(*ast.ExportStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range es.Names() {
+			sym, _ := c.symTable.Resolve(name)
+			c.exports[name] = sym.Index
+		}
+	}
+	return nil
+}
+
+// compileModule compiles the module imported by node into a constant, unless
+// it was already compiled, and returns the index of the constant. A module
+// from the registry is its own constant.
+func (c *Compiler) compileModule(node *ast.ImportExpression) (int, error) {
+	name := node.Path.Value
+	if c.modules != nil && c.modules.registry != nil {
+		if idx, ok := c.modules.natives[name]; ok {
+			return idx, nil
+		}
+		if m, ok := c.modules.registry.Lookup(name); ok {
+			idx := c.addConstant(m)
+			c.modules.natives[name] = idx
+			return idx, nil
+		}
+	}
+	if c.modules == nil || c.modules.loader == nil {
+		return 0, fmt.Errorf("%s: cannot import %q: no module loader", node.Pos(), name)
+	}
+	path, err := module.Resolve(c.path, name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", node.Pos(), err)
+	}
+	if idx, ok := c.modules.compiled[path]; ok {
+		return idx, nil
+	}
+	for i, p := range c.modules.loading {
+		if p == path {
+			cycle := append(append([]string{}, c.modules.loading[i:]...), path)
+			return 0, fmt.Errorf("%s: import cycle: %s", node.Pos(), strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := c.modules.loader.Load(path)
+	if err != nil {
+		return 0, fmt.Errorf("%s: cannot import %q: %w", node.Pos(), name, err)
+	}
+	prog, err := parser.New(lexer.New(string(src))).ParseProgram()
+	if err != nil {
+		return 0, &ModuleError{Path: path, Err: err}
+	}
+
+	mc := New()
+	mc.constants = c.constants
+	mc.path = path
+	mc.modules = c.modules
+	c.modules.loading = append(c.modules.loading, path)
+	err = mc.Compile(prog)
+	c.modules.loading = c.modules.loading[:len(c.modules.loading)-1]
+	if err != nil {
+		var me *ModuleError
+		if errors.As(err, &me) {
+			return 0, err
+		}
+		return 0, &ModuleError{Path: path, Err: err}
+	}
+	mc.emit(code.OpReturn)
+	c.constants = mc.constants
+	c.warnings = append(c.warnings, mc.warnings...)
+
+	mod := &object.CompiledModule{
+		Path:       path,
+		Fn:         &object.CompiledFunc{Instructions: mc.currentInstructions()},
+		NumGlobals: mc.symTable.numDefinitions,
+		Exports:    mc.exports,
+	}
+	idx := c.addConstant(mod)
+	c.modules.compiled[path] = idx
+	return idx, nil
+}
+
+// compileStatements compiles the function statements of a block before its
+// other statements, so they can be called anywhere in it. The variables of
+// the block are predeclared for them.
+func (c *Compiler) compileStatements(stmts []ast.Statement) error {
+	for _, s := range stmts {
+		if fs, ok := s.(*ast.FunctionStatement); ok {
+			c.symTable.Declare(fs.Name.Value)
+		}
+	}
+	for _, s := range stmts {
+		if ls, ok := s.(*ast.LetStatement); ok {
+			for _, name := range ls.Names() {
+				c.symTable.Predeclare(name)
+			}
+		}
+	}
+
+	for _, hoisted := range []bool{true, false} {
+		for _, s := range stmts {
+			if _, ok := s.(*ast.FunctionStatement); ok != hoisted {
+				continue
+			}
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compileBranch compiles a branch of an if expression so that it leaves the
+// value of its last expression statement on the stack, or null if it does not
+// end in one.
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case endsInExpression(block):
+		c.removeLastPop()
+	case len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpRetVal):
+	default:
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// endsInExpression reports whether the last statement of block is an
+// expression statement, which the compiler ends with an OpPop of its value.
+// Other statements may end in an OpPop of a temporary.
+func endsInExpression(block *ast.BlockStatement) bool {
+	n := len(block.Statements)
+	if n == 0 {
+		return false
+	}
+	_, ok := block.Statements[n-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+func (c *Compiler) enterLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{held: scope.held})
+}
+
+// leaveLoop patches the jumps of the innermost loop's continue and break
+// statements to continueTo and breakTo.
+func (c *Compiler) leaveLoop(continueTo, breakTo int) {
+	scope := &c.scopes[c.scopeIndex]
+	l := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range l.continues {
+		c.changeOperand(pos, continueTo)
+	}
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, breakTo)
+	}
+}
+
+// hold records that n more values stay on the stack for the expression being
+// compiled, or that it used -n of them.
+func (c *Compiler) hold(n int) {
+	c.scopes[c.scopeIndex].held += n
+}
+
+// unwind pops the values held on the stack since the start of l, for a break
+// or continue inside an expression, as in f(if (c) { break }).
+func (c *Compiler) unwind(l *loop) {
+	for i := l.held; i < c.scopes[c.scopeIndex].held; i++ {
+		c.emit(code.OpPop)
+	}
+}
+
+// emitLoopValue ends a loop statement. A loop has no value, so it leaves null
+// as the last popped value, like the evaluator, rather than whatever the loop
+// popped last.
+func (c *Compiler) emitLoopValue() {
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -329,9 +1223,9 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction := code.Make(op, operands...)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
@@ -378,6 +1272,21 @@ func (c *Compiler) Bytecode() *Bytecode {
 	}
 }
 
+// Warnings returns the problems found in code that compiled, such as match
+// arms that can never be taken.
+func (c *Compiler) Warnings() []string {
+	return c.warnings
+}
+
+// warnf records a warning about the code at pos.
+func (c *Compiler) warnf(pos token.Position, format string, a ...interface{}) {
+	msg := pos.String() + ": " + fmt.Sprintf(format, a...)
+	if c.path != "" {
+		msg = c.path + ": " + msg
+	}
+	c.warnings = append(c.warnings, msg)
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions:        code.Instructions{},
@@ -419,3 +1328,54 @@ func (c *Compiler) loadSymbol(s Sym) {
 		c.emit(code.OpCurrentClosure)
 	}
 }
+
+// storeSymbol emits the instruction popping a value into the variable s.
+func (c *Compiler) storeSymbol(s Sym) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol emits the instruction pushing the free variable s for
+// OpClosure. Locals are pushed as a cell shared by the closure and the
+// enclosing function, so an assignment in either is seen by both.
+func (c *Compiler) captureSymbol(s Sym) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// assignable reports whether s is a variable, as opposed to a builtin or the
+// name a function uses to refer to itself.
+// assignsTo reports whether fn assigns to the variable name anywhere.
+func assignsTo(fn *ast.FunctionLiteral, name string) bool {
+	found := false
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if a, ok := n.(*ast.AssignExpression); ok {
+			if id, ok := a.Target.(*ast.Identifier); ok && id.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func (c *Compiler) assignable(s Sym) bool {
+	table := c.symTable.function()
+	for s.Scope == FreeScope {
+		s = table.FreeSyms[s.Index]
+		table = table.Outer.function()
+	}
+	return s.Scope == GlobalScope || s.Scope == LocalScope
+}
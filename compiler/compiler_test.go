@@ -1,10 +1,12 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"iscript/ast"
 	"iscript/code"
 	"iscript/lexer"
+	"iscript/module"
 	"iscript/object"
 	"iscript/parser"
 	"testing"
@@ -79,10 +81,45 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
 
+type compilerErrorTestCase struct {
+	input string
+	want  string
+}
+
+func runCompilerErrorTests(t *testing.T, tests []compilerErrorTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("parsing error: %s", err)
+		}
+
+		err = New().Compile(program)
+		if err == nil {
+			t.Errorf("%q: expected compiler error but none", tt.input)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%q: wrong compiler error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 
@@ -156,6 +193,11 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObj failed: %s", i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObj failed: %s", i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -187,6 +229,17 @@ func testStringObject(expected string, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+	return nil
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 	if !ok {
@@ -228,11 +281,11 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -299,28 +352,712 @@ func TestConditionals(t *testing.T) {
 			expectedConstants: []interface{}{10, 3333},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
-				code.Make(code.OpJNT, 10),
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpJmp, 11),
-				code.Make(code.OpNull),
-				code.Make(code.OpPop),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpJNT, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJmp, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			if (true) { 10 } else { 20 }; 3333;
+			`,
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJNT, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJmp, 13),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			if (false) { 10 } else if (true) { 20 } else { 30 }; 3333;
+			`,
+			expectedConstants: []interface{}{10, 20, 30, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpJNT, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJmp, 23),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJNT, 20),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpJmp, 23),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 } else if (true) { 20 }",
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpJNT, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJmp, 21),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJNT, 20),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpJmp, 21),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBranchWithoutValue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { let a = 1; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJNT, 14),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpJmp, 15),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = true; while (x) { x = false; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJNT, 21),
+				code.Make(code.OpFalse),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpJmp, 4),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (let i = 0; i < 3; i += 1) { if (i) { break; } continue; }",
+			expectedConstants: []interface{}{0, 3, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpJNT, 51),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJNT, 29),
+				code.Make(code.OpJmp, 51),
+				code.Make(code.OpNull),
+				code.Make(code.OpJmp, 30),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpJmp, 34),
+				// 0034
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpJmp, 6),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (;;) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpJmp, 6),
+				code.Make(code.OpJmp, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (;;) { 1 + if (true) { break } }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJNT, 15),
+				// 0007, pops the 1 before leaving the loop
+				code.Make(code.OpPop),
+				code.Make(code.OpJmp, 21),
+				code.Make(code.OpNull),
+				code.Make(code.OpJmp, 16),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpJmp, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestForIn(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpIterInit),
+				// 0007
+				code.Make(code.OpIterNext, 21, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpJmp, 7),
+				// 0021
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (k, v in []) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpIterInit),
+				// 0004
+				code.Make(code.OpIterNext, 20, 2),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpJmp, 20),
+				code.Make(code.OpJmp, 4),
+				// 0020
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let [a, ...b] = [];",
+			expectedConstants: []interface{}{0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCheckArray, 1, 1),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(p) { let {x: [y]} = p; }`,
+			expectedConstants: []interface{}{
+				"x",
+				"x",
+				0,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCheckHash, 1),
+					code.Make(code.OpDup, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpIndex),
+					code.Make(code.OpCheckArray, 1, 0),
+					code.Make(code.OpDup, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpIndex),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpPop),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []compilerErrorTestCase{
+		{"break", "1:1: break outside loop"},
+		{"if (true) { continue; }", "1:13: continue outside loop"},
+		{"fn() { break }", "1:8: break outside loop"},
+		{"while (true) { fn() { continue } }", "1:23: continue outside loop"},
+	}
+
+	runCompilerErrorTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match (1) { 2 => 10, [a] => a }",
+			expectedConstants: []interface{}{1, 2, 10, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				// 2 => 10
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMatchValue),
+				code.Make(code.OpJNT, 19),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpJmp, 50),
+				// [a] => a
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpMatchArray, 1, 0),
+				code.Make(code.OpJNT, 47),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJmp, 50),
+				code.Make(code.OpPop),
+				// no arm matched
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `match ({}) { {k: v} if v => 1 }`,
+			expectedConstants: []interface{}{"k", "k", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMatchHash, 1),
+				code.Make(code.OpJNT, 38),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJNT, 39),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpJmp, 41),
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMembers(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a".upper()`,
+			expectedConstants: []interface{}{"a", "upper"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMember, 1),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let h = {}; h.n += 1",
+			expectedConstants: []interface{}{"n", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSlices(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][1:]",
+			expectedConstants: []interface{}{1, 2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"ab"[:-1]`,
+			expectedConstants: []interface{}{"ab", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMinus),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	loader := module.Map{
+		"lib/u.is": "export let x = 1; let y = 2; export fn f(a) { a }",
+	}
+	input := `let u = import("./lib/u.is"); import("./lib/u.is").x`
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	compiler := New()
+	compiler.SetLoader(loader)
+	err = compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpImport, 3),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpImport, 3),
+		code.Make(code.OpMember, 4),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants(t, []interface{}{
+		[]code.Instructions{
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpRetVal),
+		},
+		1,
+		2,
+	}, bytecode.Constants[:3])
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	mod, ok := bytecode.Constants[3].(*object.CompiledModule)
+	if !ok {
+		t.Fatalf("constant 3 is not a module: %T", bytecode.Constants[3])
+	}
+	if mod.Path != "lib/u.is" || mod.NumGlobals != 3 {
+		t.Errorf("wrong module. got path=%q, globals=%d", mod.Path, mod.NumGlobals)
+	}
+	if diff := cmp.Diff(map[string]int{"f": 0, "x": 1}, mod.Exports); diff != "" {
+		t.Errorf("wrong exports (-want +got):\n%s", diff)
+	}
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpClosure, 0, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpSetGlobal, 1),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpSetGlobal, 2),
+		code.Make(code.OpReturn),
+	}, mod.Fn.Instructions)
+	if err != nil {
+		t.Fatalf("module testInstructions failed: %s", err)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	loader := module.Map{
+		"a.is":     `import("./b.is")`,
+		"b.is":     `import("./a.is")`,
+		"self.is":  `import("./self.is")`,
+		"bad.is":   "let x = ;",
+		"undef.is": "export let x = y;",
+		"use.is":   `import("./undef.is")`,
+	}
+	tests := []compilerErrorTestCase{
+		{`import("./a.is")`, `b.is: 1:1: import cycle: a.is -> b.is -> a.is`},
+		{`import("./self.is")`, `self.is: 1:1: import cycle: self.is -> self.is`},
+		{`let m = import("./none.is")`, `1:9: cannot import "./none.is": open none.is: file does not exist`},
+		{`import("../up.is")`, `1:1: invalid module path "../up.is"`},
+		{`import("./use.is")`, "undef.is: 1:16: undefined variable: y"},
+		{"if (true) { export let x = 1 }", "1:13: export outside the top level of a module"},
+	}
+
+	for _, tt := range tests {
+		program, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("parsing error: %s", err)
+		}
+
+		compiler := New()
+		compiler.SetLoader(loader)
+		err = compiler.Compile(program)
+		if err == nil {
+			t.Errorf("%q: expected compiler error but none", tt.input)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%q: wrong compiler error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
+
+	program, err := parse(`import("./bad.is")`)
+	if err != nil {
+		t.Fatalf("parsing error: %s", err)
+	}
+	compiler := New()
+	compiler.SetLoader(loader)
+	err = compiler.Compile(program)
+	var me *ModuleError
+	if !errors.As(err, &me) || me.Path != "bad.is" {
+		t.Errorf("expected a syntax error in bad.is, got %v", err)
+	}
+
+	runCompilerErrorTests(t, []compilerErrorTestCase{
+		{`import("./a.is")`, `1:1: cannot import "./a.is": no module loader`},
+	})
+}
+
+func TestRegistryImports(t *testing.T) {
+	registry := module.NewRegistry()
+	err := registry.Register("strings", map[string]object.Object{"sep": &object.String{Value: ","}})
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	loader := module.Map{
+		"strings":  "export let sep = 1;",
+		"lib/a.is": `export let s = import("strings");`,
+	}
+
+	program, err := parse(`import("strings").sep; import("./lib/a.is"); import("strings")`)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	compiler := New()
+	compiler.SetLoader(loader)
+	compiler.SetRegistry(registry)
+	err = compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpImport, 0),
+		code.Make(code.OpMember, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpImport, 2),
+		code.Make(code.OpPop),
+		code.Make(code.OpImport, 0),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpImport, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpReturn),
+	}, bytecode.Constants[2].(*object.CompiledModule).Fn.Instructions)
+	if err != nil {
+		t.Fatalf("module testInstructions failed: %s", err)
+	}
+	m, _ := registry.Lookup("strings")
+	if bytecode.Constants[0] != m {
+		t.Errorf("constant 0 is not the registered module: %s", bytecode.Constants[0].Inspect())
+	}
+
+	// A module without a loader can still import from the registry.
+	program, err = parse(`import("strings"); import("./lib/a.is")`)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	compiler = New()
+	compiler.SetRegistry(registry)
+	err = compiler.Compile(program)
+	if want := `1:20: cannot import "./lib/a.is": no module loader`; err == nil || err.Error() != want {
+		t.Errorf("wrong compiler error. want=%q, got=%v", want, err)
+	}
+}
+
+func TestMatchScopeErrors(t *testing.T) {
+	tests := []compilerErrorTestCase{
+		{"match (5) { zz => zz }; zz", "1:25: undefined variable: zz"},
+		{"fn(v) { match (v) { [a] => 1, _ => a } }", "1:36: undefined variable: a"},
+		{"match ([1]) { [a] if a > 0 => 1, _ if a => 2 }", "1:39: undefined variable: a"},
+	}
+
+	runCompilerErrorTests(t, tests)
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"match (1) { n => 1, 2 => 2, _ => 3 }", []string{
+			"1:21: unreachable match arm, n at 1:13 matches everything",
+			"1:29: unreachable match arm, n at 1:13 matches everything",
+		}},
+		{"match (1) { n if n > 0 => 1, _ => 2 }", nil},
+		{"match (1) { [_] => 1, {a} => 2, _ => 3 }", nil},
+	}
+
+	for _, tt := range tests {
+		program, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("%q: parse error: %s", tt.input, err)
+		}
+
+		compiler := New()
+		err = compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+
+		if diff := cmp.Diff(tt.want, compiler.Warnings()); diff != "" {
+			t.Errorf("%q: wrong warnings (-want +got):\n%s", tt.input, diff)
+		}
+	}
+}
+
+func TestOperatorOpcodes(t *testing.T) {
+	ops := []struct {
+		operator string
+		opcode   code.Opcode
+	}{
+		{"<", code.OpLessThan},
+		{"<=", code.OpLessEqual},
+		{">=", code.OpGreaterEqual},
+		{"%", code.OpMod},
+		{"**", code.OpPow},
+		{"&", code.OpBitAnd},
+		{"|", code.OpBitOr},
+		{"^", code.OpBitXor},
+		{"<<", code.OpShl},
+		{">>", code.OpShr},
+	}
+
+	tests := []compilerTestCase{
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	for _, op := range ops {
+		tests = append(tests, compilerTestCase{
+			input:             "1 " + op.operator + " 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(op.opcode),
+				code.Make(code.OpPop),
+			},
+		})
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJNT, 12),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJNT, 12),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJmp, 13),
+				code.Make(code.OpFalse),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input: `
-			if (true) { 10 } else { 20 }; 3333;
-			`,
-			expectedConstants: []interface{}{10, 20, 3333},
+			input:             "true || false",
+			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
-				code.Make(code.OpJNT, 10),
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpJmp, 13),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpJNT, 7),
+				code.Make(code.OpJmp, 11),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJNT, 15),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJmp, 16),
+				code.Make(code.OpFalse),
 				code.Make(code.OpPop),
 			},
 		},
@@ -401,6 +1138,35 @@ func TestStringExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a${1}b${2}c"`,
+			expectedConstants: []interface{}{"a", 1, "b", 2, "c"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConcat, 5),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"${1}${2}"`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConcat, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -743,6 +1509,265 @@ func TestFunctionCalls(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let x = 1; x = 2; }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpRetVal),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let x = 1; fn() { x = 2; } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpRetVal),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpRetVal),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompoundAndIndexAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x += 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] = 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] *= 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []compilerErrorTestCase{
+		{"y = 1", "1:1: assignment to undeclared variable: y"},
+		{"fn() { z = 1 }", "1:8: assignment to undeclared variable: z"},
+		{"len = 1", "1:1: cannot assign to len"},
+		{"q += 1", "1:1: assignment to undeclared variable: q"},
+	}
+
+	runCompilerErrorTests(t, tests)
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a, b = 1) { a }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpJmp, 8),
+					// 0003, default of b
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpRetVal),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "len(1, ...[])",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCallSpread, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "f(); fn f() { 1 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpRetVal),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { fn a() { b() } fn b() { 1 } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpRetVal),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpRetVal),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 1),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDefaultParameterErrors(t *testing.T) {
+	tests := []compilerErrorTestCase{
+		{"fn(a = b, b = 1) { a }", "1:8: undefined variable: b"},
+		{"fn(a = c) { let c = 1; a }", "1:8: undefined variable: c"},
+		{"fn(a = r, ...r) { a }", "1:8: undefined variable: r"},
+	}
+
+	runCompilerErrorTests(t, tests)
+}
+
+func TestFunctionArity(t *testing.T) {
+	tests := []struct {
+		input    string
+		min, max int
+		defaults []int
+	}{
+		{"fn() {}", 0, 0, nil},
+		{"fn(a, b) { a }", 2, 2, nil},
+		{"fn(a, b = 1, c = 2) { a }", 1, 3, []int{3, 8}},
+		{"fn(a, ...r) { a }", 1, -1, nil},
+		{"fn(a = 1, ...r) { a }", 0, -1, []int{3}},
+	}
+
+	for _, tt := range tests {
+		program, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("parsing error: %s", err)
+		}
+
+		comp := New()
+		err = comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		consts := comp.Bytecode().Constants
+		fn := consts[len(consts)-1].(*object.CompiledFunc)
+		if fn.MinArity != tt.min || fn.MaxArity != tt.max {
+			t.Errorf("%q: wrong arity. want=%d..%d, got=%d..%d", tt.input, tt.min, tt.max, fn.MinArity, fn.MaxArity)
+		}
+		if diff := cmp.Diff(tt.defaults, fn.Defaults); diff != "" {
+			t.Errorf("%q: wrong Defaults (-want +got):\n%s", tt.input, diff)
+		}
+	}
+}
+
 func TestLetStatementScopes(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -854,7 +1879,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpRetVal),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpRetVal),
 				},
@@ -871,6 +1896,7 @@ func TestClosures(t *testing.T) {
 					fn(c) {
 						a + b + c
 					}
+				}
 			}
 			`,
 			expectedConstants: []interface{}{
@@ -883,13 +1909,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpRetVal),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpRetVal),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpRetVal),
 				},
@@ -937,15 +1963,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpRetVal),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpRetVal),
 				},
@@ -1064,7 +2090,7 @@ func TestMemoFib(t *testing.T) {
 					code.Make(code.OpRetVal),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpRetVal),
 				},
@@ -1099,8 +2125,8 @@ func TestMemoFib(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpHash),
 					code.Make(code.OpSetLocal, 1),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 1),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 2, 2),
 					code.Make(code.OpRetVal),
 				},
@@ -1108,9 +2134,9 @@ func TestMemoFib(t *testing.T) {
 				1,
 				2,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 4),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGreaterThan),
+					code.Make(code.OpConstant, 4),
+					code.Make(code.OpLessThan),
 					code.Make(code.OpJNT, 15),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpRetVal),
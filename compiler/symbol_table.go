@@ -22,6 +22,13 @@ type SymTable struct {
 	store          map[string]Sym
 	numDefinitions int
 	FreeSyms       []Sym
+
+	// pending holds the names given a slot by Predeclare that are not
+	// declared yet. Only nested functions can resolve them.
+	pending map[string]bool
+	// block is set for the table of a scope inside a function, such as a
+	// match arm, whose variables take slots of the function.
+	block bool
 }
 
 func NewSymTable() *SymTable {
@@ -31,20 +38,68 @@ func NewSymTable() *SymTable {
 }
 
 func (s *SymTable) Define(name string) Sym {
-	sym := Sym{Name: name, Index: s.numDefinitions}
+	fn := s.function()
+	sym := Sym{Name: name, Index: fn.numDefinitions}
 	sym.Scope = LocalScope
-	if s.Outer == nil {
+	if fn.Outer == nil {
 		sym.Scope = GlobalScope
 	}
 	s.store[name] = sym
-	s.numDefinitions++
+	fn.numDefinitions++
 	return sym
 }
 
+// Predeclare gives name a slot before its declaration is compiled, so the
+// functions of a block can refer to the variables declared anywhere in it.
+// Until Declare is called for it, the scope itself does not see name.
+func (s *SymTable) Predeclare(name string) Sym {
+	if sym, ok := s.own(name); ok {
+		return sym
+	}
+	sym := s.Define(name)
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	s.pending[name] = true
+	return sym
+}
+
+// Declare defines name, reusing the slot of a variable of the same name
+// already declared or predeclared in this scope.
+func (s *SymTable) Declare(name string) Sym {
+	if sym, ok := s.own(name); ok {
+		delete(s.pending, name)
+		return sym
+	}
+	return s.Define(name)
+}
+
+// own returns the variable name of this scope, as opposed to a free one.
+func (s *SymTable) own(name string) (Sym, bool) {
+	sym, ok := s.store[name]
+	if !ok || sym.Scope != GlobalScope && sym.Scope != LocalScope {
+		return Sym{}, false
+	}
+	return sym, true
+}
+
 func (s *SymTable) Resolve(name string) (Sym, bool) {
+	return s.resolve(name, false)
+}
+
+// resolve looks name up; nested is set when the lookup comes from a function
+// inside this scope, which sees the pending names too.
+func (s *SymTable) resolve(name string, nested bool) (Sym, bool) {
 	obj, ok := s.store[name]
+	shadowed := ok && !nested && s.pending[name]
+	if shadowed {
+		ok = false
+	}
+	if !ok && s.block {
+		return s.Outer.resolve(name, nested)
+	}
 	if !ok && s.Outer != nil {
-		obj, ok = s.Outer.Resolve(name)
+		obj, ok = s.Outer.resolve(name, true)
 		if !ok {
 			return obj, ok
 		}
@@ -53,6 +108,9 @@ func (s *SymTable) Resolve(name string) (Sym, bool) {
 			return obj, ok
 		}
 
+		if shadowed {
+			return s.freeSym(obj), true
+		}
 		free := s.defineFree(obj)
 		return free, true
 	}
@@ -66,6 +124,22 @@ func NewEnclosedSymTable(outer *SymTable) *SymTable {
 	return s
 }
 
+// NewBlockSymTable returns the table of a scope nested in the function of
+// outer. Its variables are hidden from outer but live in the same frame.
+func NewBlockSymTable(outer *SymTable) *SymTable {
+	s := NewEnclosedSymTable(outer)
+	s.block = true
+	return s
+}
+
+// function returns the table of the function s belongs to.
+func (s *SymTable) function() *SymTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
+}
+
 func (s *SymTable) DefineBuiltin(index int, name string) Sym {
 	sym := Sym{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = sym
@@ -82,8 +156,28 @@ func (s *SymTable) defineFree(orig Sym) Sym {
 	return sym
 }
 
+// freeSym is defineFree for a name whose entry in the store must stay, as it
+// belongs to a pending variable.
+func (s *SymTable) freeSym(orig Sym) Sym {
+	for i, f := range s.FreeSyms {
+		if f == orig {
+			return Sym{Name: orig.Name, Index: i, Scope: FreeScope}
+		}
+	}
+	s.FreeSyms = append(s.FreeSyms, orig)
+	return Sym{Name: orig.Name, Index: len(s.FreeSyms) - 1, Scope: FreeScope}
+}
+
 func (s *SymTable) DefineFunctionName(name string) Sym {
 	sym := Sym{Name: name, Index: 0, Scope: FunctionScope}
 	s.store[name] = sym
 	return sym
 }
+
+// defineParam makes a parameter visible under the local slot reserved for it
+// when the function scope was entered.
+func (s *SymTable) defineParam(name string, index int) Sym {
+	sym := Sym{Name: name, Index: index, Scope: LocalScope}
+	s.store[name] = sym
+	return sym
+}
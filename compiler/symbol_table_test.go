@@ -318,3 +318,61 @@ func TestShadowingFuncName(t *testing.T) {
 		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, res)
 	}
 }
+
+func TestPredeclare(t *testing.T) {
+	global := NewSymTable()
+	global.Define("a")
+	local := NewEnclosedSymTable(global)
+	local.Define("a")
+	local.Predeclare("a")
+	local.Predeclare("b")
+	nested := NewEnclosedSymTable(local)
+
+	res, ok := local.Resolve("b")
+	if ok {
+		t.Errorf("pending name b resolved to %+v", res)
+	}
+	want := Sym{Name: "b", Scope: FreeScope, Index: 0}
+	if res, _ := nested.Resolve("b"); res != want {
+		t.Errorf("expected b to resolve to %+v in a nested function, got=%+v", want, res)
+	}
+
+	want = Sym{Name: "a", Scope: LocalScope, Index: 0}
+	if res, _ := local.Resolve("a"); res != want {
+		t.Errorf("expected a to keep its slot %+v, got=%+v", want, res)
+	}
+
+	want = Sym{Name: "b", Scope: LocalScope, Index: 1}
+	if res := local.Declare("b"); res != want {
+		t.Errorf("expected b to be declared in its slot %+v, got=%+v", want, res)
+	}
+	if res, _ := local.Resolve("b"); res != want {
+		t.Errorf("expected b to resolve to %+v, got=%+v", want, res)
+	}
+}
+
+func TestBlockSymTable(t *testing.T) {
+	global := NewSymTable()
+	global.Define("a")
+	local := NewEnclosedSymTable(global)
+	local.Define("b")
+	block := NewBlockSymTable(local)
+	block.Define("a")
+	nested := NewEnclosedSymTable(block)
+
+	want := Sym{Name: "a", Scope: LocalScope, Index: 1}
+	if res, _ := block.Resolve("a"); res != want {
+		t.Errorf("expected a to resolve to %+v in the block, got=%+v", want, res)
+	}
+	want = Sym{Name: "a", Scope: GlobalScope, Index: 0}
+	if res, _ := local.Resolve("a"); res != want {
+		t.Errorf("expected a to resolve to %+v outside the block, got=%+v", want, res)
+	}
+	want = Sym{Name: "a", Scope: FreeScope, Index: 0}
+	if res, _ := nested.Resolve("a"); res != want {
+		t.Errorf("expected a to resolve to %+v in a nested function, got=%+v", want, res)
+	}
+	if local.numDefinitions != 2 {
+		t.Errorf("wrong number of locals of the function. want=2, got=%d", local.numDefinitions)
+	}
+}
@@ -12,4 +12,5 @@ var builtins = map[string]*object.Builtin{
 	"rest":       object.GetBuiltinByName("rest"),
 	"push":       object.GetBuiltinByName("push"),
 	"updateHash": object.GetBuiltinByName("updateHash"),
+	"range":      object.GetBuiltinByName("range"),
 }
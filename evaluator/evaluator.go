@@ -2,14 +2,16 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"iscript/ast"
 	"iscript/object"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	TRUE  = object.True
+	FALSE = object.False
 	NULL  = &object.Null{}
 )
 
@@ -20,57 +22,94 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
+	case *ast.ExportStatement:
+		return newError("export outside the top level of a module")
+	case *ast.ImportExpression:
+		return env.Import(node.Path.Value)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			if err := bindPattern(node.Pattern, val, env); err != nil {
+				return err
+			}
+			break
+		}
 		env.Set(node.Name.Value, val)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+	case *ast.BreakStatement:
+		return &object.LoopControl{Break: true}
+	case *ast.ContinueStatement:
+		return &object.LoopControl{Break: false}
+	case *ast.FunctionStatement:
+		env.Set(node.Name.Value, Eval(node.Function, env))
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{
+			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Env:        env,
+			Body:       node.Body,
+		}
 
 	//Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToObj(node.Value)
 	case *ast.NULL:
 		return NULL
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, left, env)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
@@ -78,25 +117,37 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalHashLiteral(node, env)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+	case *ast.MemberExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		return evalMember(left, node.Name.Value)
 	case *ast.CallExpression:
 		f := Eval(node.Function, env)
-		if isError(f) {
+		if isAbrupt(f) {
 			return f
 		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := evalArguments(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
 
 		return applyFunction(f, args)
+	case *ast.SpreadExpression:
+		return newError("%s: cannot spread outside of call arguments", node.Pos())
+	case *ast.BadStatement, *ast.BadExpression:
+		return newError("%s: cannot evaluate code with syntax errors", node.Pos())
 	}
 
 	return nil
@@ -109,16 +160,52 @@ func nativeBoolToObj(input bool) *object.Boolean {
 	return FALSE
 }
 
+// hoistFunctions binds the functions declared in stmts, so they can be called
+// before their declaration. It returns the last function, or nil if there is
+// none.
+func hoistFunctions(stmts []ast.Statement, env *object.Environment) object.Object {
+	var last object.Object
+	for _, stmt := range stmts {
+		if fs, ok := stmt.(*ast.FunctionStatement); ok {
+			Eval(fs, env)
+			last, _ = env.Get(fs.Name.Value)
+		}
+	}
+	return last
+}
+
 func evalProgram(p *ast.Program, env *object.Environment) object.Object {
 	var res object.Object
 
-	for _, stmt := range p.Statements {
+	if err := checkLoopControl(p); err != nil {
+		return err
+	}
+
+	stmts := make([]ast.Statement, len(p.Statements))
+	for i, stmt := range p.Statements {
+		if es, ok := stmt.(*ast.ExportStatement); ok {
+			stmt = es.Statement
+		}
+		stmts[i] = stmt
+	}
+
+	// The compiled program binds the functions first, so like the VM, a
+	// program of only function declarations has the last one as its value.
+	res = hoistFunctions(stmts, env)
+	for _, stmt := range stmts {
+		// Function declarations were bound by hoisting and leave the
+		// program's value as it was.
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			continue
+		}
 		res = Eval(stmt, env)
 		switch res := res.(type) {
 		case *object.ReturnValue:
 			return res.Value
 		case *object.Error:
 			return res
+		case *object.LoopControl:
+			return newError("%s outside loop", res.Inspect())
 		}
 	}
 
@@ -128,12 +215,19 @@ func evalProgram(p *ast.Program, env *object.Environment) object.Object {
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var res object.Object
 
+	hoistFunctions(block.Statements, env)
 	for _, stmt := range block.Statements {
+		// A block has a value only when it ends in an expression, so one
+		// ending in a function declaration is null, as in the VM.
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			res = NULL
+			continue
+		}
 		res = Eval(stmt, env)
 
 		if res != nil {
 			rt := res.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.LOOP_CONTROL_OBJ {
 				return res
 			}
 		}
@@ -141,12 +235,157 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return res
 }
 
+func evalWhileStatement(s *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		cond := Eval(s.Condition, env)
+		if isError(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return NULL
+		}
+
+		res, done := evalLoopBody(s.Body, env)
+		if done {
+			return res
+		}
+	}
+}
+
+func evalForStatement(s *ast.ForStatement, env *object.Environment) object.Object {
+	if s.Init != nil {
+		if init := Eval(s.Init, env); isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if s.Condition != nil {
+			cond := Eval(s.Condition, env)
+			if isError(cond) {
+				return cond
+			}
+			if !isTruthy(cond) {
+				return NULL
+			}
+		}
+
+		res, done := evalLoopBody(s.Body, env)
+		if done {
+			return res
+		}
+
+		if s.Update != nil {
+			if update := Eval(s.Update, env); isError(update) {
+				return update
+			}
+		}
+	}
+}
+
+func evalForInStatement(s *ast.ForInStatement, env *object.Environment) object.Object {
+	coll := Eval(s.Iterable, env)
+	if isError(coll) {
+		return coll
+	}
+	iterable, ok := coll.(object.Iterable)
+	if !ok {
+		return newError("cannot iterate over %s", coll.Type())
+	}
+
+	it := iterable.Iter()
+	for {
+		key, val, ok := it.Next()
+		if !ok {
+			return NULL
+		}
+		if s.Key != nil {
+			env.Set(s.Key.Value, key)
+		}
+		env.Set(s.Value.Value, val)
+
+		res, done := evalLoopBody(s.Body, env)
+		if done {
+			return res
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop. It reports whether the loop is
+// finished, along with the loop's result if it is.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	switch res := evalBlockStatement(body, env).(type) {
+	case *object.ReturnValue, *object.Error:
+		return res, true
+	case *object.LoopControl:
+		if res.Break {
+			return NULL, true
+		}
+	}
+	return nil, false
+}
+
+// checkLoopControl returns an error for the first break or continue in node
+// that is not inside a loop of its own function. The compiler rejects these
+// before running anything, so the evaluator checks a program up front rather
+// than only failing when one is reached.
+func checkLoopControl(node ast.Node) *object.Error {
+	var err *object.Error
+	var check func(node ast.Node, inLoop bool)
+	check = func(node ast.Node, inLoop bool) {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			switch n := n.(type) {
+			case *ast.BreakStatement:
+				if !inLoop {
+					err = newError("%s: break outside loop", n.Pos())
+				}
+			case *ast.ContinueStatement:
+				if !inLoop {
+					err = newError("%s: continue outside loop", n.Pos())
+				}
+			case *ast.WhileStatement:
+				check(n.Condition, inLoop)
+				check(n.Body, true)
+				return false
+			case *ast.ForStatement:
+				check(n.Init, inLoop)
+				check(n.Condition, inLoop)
+				check(n.Update, true)
+				check(n.Body, true)
+				return false
+			case *ast.ForInStatement:
+				check(n.Iterable, inLoop)
+				check(n.Body, true)
+				return false
+			case *ast.FunctionLiteral:
+				// A function starts outside any loop.
+				if inLoop {
+					check(n, false)
+					return false
+				}
+			}
+			return true
+		})
+	}
+	check(node, false)
+	return err
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOpExpression(right)
 	case "-":
 		return evalMinusPrefixOpExp(right)
+	case "~":
+		integer, ok := right.(*object.Integer)
+		if !ok {
+			return newError("unknown operator: ~%s", right.Type())
+		}
+		return &object.Integer{Value: ^integer.Value}
 	default:
 		return newError("unknown operator %s%s", operator, right.Type())
 	}
@@ -166,12 +405,14 @@ func evalBangOpExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOpExp(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalStringInfixExpression(op string, left, right object.Object) object.Object {
@@ -188,6 +429,8 @@ func evalInfixExpression(op string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfix(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalFloatInfix(op, left, right)
 	case op == "==":
 		return nativeBoolToObj(left == right)
 	case op == "!=":
@@ -213,11 +456,78 @@ func evalIntegerInfix(op string, left, right object.Object) object.Object {
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		return &object.Integer{Value: object.IntPow(leftVal, rightVal)}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if op == "<<" {
+			return &object.Integer{Value: leftVal << rightVal}
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
 	case "<":
 		return nativeBoolToObj(leftVal < rightVal)
 	case ">":
 		return nativeBoolToObj(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToObj(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToObj(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToObj(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToObj(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
+}
+
+// evalFloatInfix handles floats and mixed integer and float operands. The
+// integer side is widened to a float.
+func evalFloatInfix(op string, left, right object.Object) object.Object {
+	leftVal := object.ToFloat(left)
+	rightVal := object.ToFloat(right)
+
+	switch op {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToObj(leftVal < rightVal)
+	case ">":
+		return nativeBoolToObj(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToObj(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToObj(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToObj(leftVal == rightVal)
 	case "!=":
@@ -228,18 +538,119 @@ func evalIntegerInfix(op string, left, right object.Object) object.Object {
 }
 
 func evalIfExpression(e *ast.IfExpression, env *object.Environment) object.Object {
-	cond := Eval(e.Condition, env)
-	if isError(cond) {
-		return cond
+	for _, b := range e.Branches {
+		cond := Eval(b.Condition, env)
+		if isAbrupt(cond) {
+			return cond
+		}
+
+		if isTruthy(cond) {
+			return Eval(b.Consequence, env)
+		}
 	}
 
-	if isTruthy(cond) {
-		return Eval(e.Consequence, env)
-	} else if e.Alternative != nil {
+	if e.Alternative != nil {
 		return Eval(e.Alternative, env)
-	} else {
-		return NULL
 	}
+	return NULL
+}
+
+func evalMatchExpression(e *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(e.Subject, env)
+	if isAbrupt(subject) {
+		return subject
+	}
+
+	for _, arm := range e.Arms {
+		// Each arm binds its names in its own scope, which a failed arm
+		// leaves behind.
+		armEnv := object.NewEnclosedEnvironment(env)
+		if !matchPattern(arm.Pattern, subject, armEnv) {
+			continue
+		}
+
+		if arm.Guard != nil {
+			cond := Eval(arm.Guard, armEnv)
+			if isAbrupt(cond) {
+				return cond
+			}
+			if !isTruthy(cond) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return NULL
+}
+
+// matchPattern reports whether val matches pat, binding the names in pat as it
+// goes.
+func matchPattern(pat ast.Expression, val object.Object, env *object.Environment) bool {
+	switch pat := pat.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.Identifier:
+		env.Set(pat.Value, val)
+		return true
+	case *ast.ArrayPattern:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return false
+		}
+		n := len(pat.Elements)
+		if len(arr.Elements) < n || pat.Rest == nil && len(arr.Elements) != n {
+			return false
+		}
+
+		for i, el := range pat.Elements {
+			if !matchPattern(el, arr.Elements[i], env) {
+				return false
+			}
+		}
+		if pat.Rest != nil {
+			rest := make([]object.Object, len(arr.Elements)-n)
+			copy(rest, arr.Elements[n:])
+			env.Set(pat.Rest.Value, &object.Array{Elements: rest})
+		}
+		return true
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for _, p := range pat.Pairs {
+			key := &object.String{Value: p.Key.Value}
+			if _, ok := hash.Pairs[key.HashKey()]; !ok {
+				return false
+			}
+		}
+
+		for _, p := range pat.Pairs {
+			key := &object.String{Value: p.Key.Value}
+			if !matchPattern(p.Value, hash.Pairs[key.HashKey()].Value, env) {
+				return false
+			}
+		}
+		return true
+	default:
+		return object.MatchesValue(Eval(pat, env), val)
+	}
+}
+
+// evalLogicalExpression evaluates the right operand of && and || only when
+// left does not already decide the result.
+func evalLogicalExpression(node *ast.InfixExpression, left object.Object, env *object.Environment) object.Object {
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToObj(isTruthy(left))
+	}
+
+	right := Eval(node.Right, env)
+	if isAbrupt(right) {
+		return right
+	}
+	return nativeBoolToObj(isTruthy(right))
 }
 
 func isTruthy(obj object.Object) bool {
@@ -266,6 +677,17 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// isAbrupt reports whether obj ends the evaluation of the expression using it
+// as a value: an error, or a break, continue or return inside an if or match
+// expression, which is passed up to the loop or function it leaves.
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.LoopControl, *object.ReturnValue:
+		return true
+	}
+	return false
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
@@ -283,7 +705,7 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 
 	for _, e := range exps {
 		got := Eval(e, env)
-		if isError(got) {
+		if isAbrupt(got) {
 			return []object.Object{got}
 		}
 		res = append(res, got)
@@ -292,10 +714,43 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return res
 }
 
+// evalArguments evaluates the arguments of a call, replacing spread arrays by
+// their elements.
+func evalArguments(exps []ast.Expression, env *object.Environment) []object.Object {
+	res := []object.Object{}
+
+	for _, e := range exps {
+		spread, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			got := Eval(e, env)
+			if isAbrupt(got) {
+				return []object.Object{got}
+			}
+			res = append(res, got)
+			continue
+		}
+
+		got := Eval(spread.Value, env)
+		if isAbrupt(got) {
+			return []object.Object{got}
+		}
+		arr, ok := got.(*object.Array)
+		if !ok {
+			return []object.Object{newError("cannot spread %s", got.Type())}
+		}
+		res = append(res, arr.Elements...)
+	}
+
+	return res
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extEnv := extendFuncEnv(fn, args)
+		extEnv, err := extendFuncEnv(fn, args)
+		if err != nil {
+			return err
+		}
 		got := Eval(fn.Body, extEnv)
 		return unwrapFnValue(got)
 	case *object.Builtin:
@@ -303,51 +758,328 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 			return result
 		}
 		return NULL
+	case *object.BoundMethod:
+		method := object.LookupMethod(fn.Receiver, fn.Name)
+		if method == nil {
+			return newError("undefined method %s for %s", fn.Name, fn.Receiver.Type())
+		}
+		return applyFunction(method, append([]object.Object{fn.Receiver}, args...))
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFuncEnv(fn *object.Function, args []object.Object) *object.Environment {
+// extendFuncEnv binds the parameters of fn to args in a new environment.
+// Default values are evaluated in that environment, so they can refer to the
+// parameters before them.
+func extendFuncEnv(fn *object.Function, args []object.Object) (*object.Environment, *object.Error) {
+	minArity := len(fn.Parameters)
+	for i, d := range fn.Defaults {
+		if d != nil {
+			minArity = i
+			break
+		}
+	}
+	maxArity := len(fn.Parameters)
+	switch {
+	case len(args) >= minArity && (fn.Rest != nil || len(args) <= maxArity):
+	case fn.Rest != nil:
+		return nil, newError("wrong number of args: want at least %d, got=%d", minArity, len(args))
+	case minArity != maxArity:
+		return nil, newError("wrong number of args: want=%d to %d, got=%d", minArity, maxArity, len(args))
+	default:
+		return nil, newError("wrong number of args: want=%d, got=%d", maxArity, len(args))
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			env.Set(param.Value, args[paramIdx])
+			continue
+		}
+		val := Eval(fn.Defaults[paramIdx], env)
+		if isError(val) {
+			return nil, val.(*object.Error)
+		}
+		env.Set(param.Value, val)
 	}
 
-	return env
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		env.Set(fn.Rest.Value, &object.Array{Elements: rest})
+	}
+
+	return env, nil
 }
 
 func unwrapFnValue(obj object.Object) object.Object {
-	if ret, ok := obj.(*object.ReturnValue); ok {
-		return ret.Value
+	switch obj := obj.(type) {
+	case *object.ReturnValue:
+		return obj.Value
+	case *object.LoopControl:
+		return newError("%s outside loop", obj.Inspect())
 	}
 
 	return obj
 }
 
+// evalAssignExpression evaluates an assignment to a variable or an array or
+// hash element. The target of a compound assignment is evaluated only once.
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	value := func(current func() object.Object) object.Object {
+		var cur object.Object
+		if node.Operator != "" {
+			cur = current()
+			if isAbrupt(cur) {
+				return cur
+			}
+		}
+		val := Eval(node.Value, env)
+		if isAbrupt(val) || node.Operator == "" {
+			return val
+		}
+		return evalInfixExpression(node.Operator, cur, val)
+	}
+
+	target := node.Target
+	if m, ok := target.(*ast.MemberExpression); ok {
+		target = m.AsIndex()
+	}
+
+	switch target := target.(type) {
+	case *ast.Identifier:
+		// Like the compiler, check the variable before evaluating anything,
+		// for plain and compound assignment alike.
+		if _, ok := env.Get(target.Value); !ok {
+			if _, ok := builtins[target.Value]; ok {
+				return newError("%s: cannot assign to %s", node.Pos(), target.Value)
+			}
+			return newError("%s: assignment to undeclared variable: %s", node.Pos(), target.Value)
+		}
+		val := value(func() object.Object { return evalIdentifier(target, env) })
+		if isAbrupt(val) {
+			return val
+		}
+		env.Assign(target.Value, val)
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		val := value(func() object.Object { return evalIndexExpression(left, index) })
+		if isAbrupt(val) {
+			return val
+		}
+		return evalSetIndex(left, index, val)
+	default:
+		return newError("cannot assign to %s", node.Target)
+	}
+}
+
+// evalMember evaluates left.name, which is a field of a hash, or else one of
+// the methods of left's type.
+func evalMember(left object.Object, name string) object.Object {
+	if m, ok := left.(*object.Module); ok {
+		return evalModuleExport(m, name)
+	}
+	if hash, ok := left.(*object.Hash); ok {
+		key := &object.String{Value: name}
+		if pair, ok := hash.Pairs[key.HashKey()]; ok {
+			return pair.Value
+		}
+		if object.LookupMethod(hash, name) == nil {
+			return NULL
+		}
+	} else if object.LookupMethod(left, name) == nil {
+		return newError("undefined method %s for %s", name, left.Type())
+	}
+	return &object.BoundMethod{Receiver: left, Name: name}
+}
+
+func evalSetIndex(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		idx, ok := object.ElementIndex(i.Value, len(left.Elements))
+		if !ok {
+			return newError("index out of range: %d with length %d", i.Value, len(left.Elements))
+		}
+		left.Elements[idx] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+	return val
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalModuleExport(left.(*object.Module), index.(*object.String).Value)
 	default:
 		return newError("index operation not supported: %s", left.Type())
 	}
 }
 
+func evalModuleExport(m *object.Module, name string) object.Object {
+	val, ok := m.Exports[name]
+	if !ok {
+		return newError("module %s has no export %s", m.Path, name)
+	}
+	return val
+}
+
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	bounds := [2]object.Object{NULL, NULL}
+	for i, b := range []ast.Expression{node.Low, node.High} {
+		if b == nil {
+			continue
+		}
+		bounds[i] = Eval(b, env)
+		if isAbrupt(bounds[i]) {
+			return bounds[i]
+		}
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		lo, hi, err := object.SliceBounds(bounds[0], bounds[1], len(left.Elements))
+		if err != nil {
+			return newError("%s", err)
+		}
+		elements := make([]object.Object, hi-lo)
+		copy(elements, left.Elements[lo:hi])
+		return &object.Array{Elements: elements}
+	case *object.String:
+		lo, hi, err := object.SliceBounds(bounds[0], bounds[1], left.Len())
+		if err != nil {
+			return newError("%s", err)
+		}
+		return &object.String{Value: left.Substr(int(lo), int(hi))}
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+// bindPattern binds the names in pat to the parts of val, returning an error
+// if val does not have the shape of pat.
+func bindPattern(pat ast.Expression, val object.Object, env *object.Environment) *object.Error {
+	switch pat := pat.(type) {
+	case *ast.Identifier:
+		env.Set(pat.Value, val)
+	case *ast.ArrayPattern:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return newError("cannot destructure %s as ARRAY", val.Type())
+		}
+		n := len(pat.Elements)
+		if pat.Rest != nil && len(arr.Elements) < n {
+			return newError("not enough values to destructure: want at least %d, got %d", n, len(arr.Elements))
+		}
+		if pat.Rest == nil && len(arr.Elements) != n {
+			return newError("wrong number of values to destructure: want %d, got %d", n, len(arr.Elements))
+		}
+
+		for i, el := range pat.Elements {
+			if err := bindPattern(el, arr.Elements[i], env); err != nil {
+				return err
+			}
+		}
+		if pat.Rest != nil {
+			rest := make([]object.Object, len(arr.Elements)-n)
+			copy(rest, arr.Elements[n:])
+			env.Set(pat.Rest.Value, &object.Array{Elements: rest})
+		}
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return newError("cannot destructure %s as HASH", val.Type())
+		}
+		for _, p := range pat.Pairs {
+			key := &object.String{Value: p.Key.Value}
+			if _, ok := hash.Pairs[key.HashKey()]; !ok {
+				return newError("missing key to destructure: %s", key.Inspect())
+			}
+		}
+
+		for _, p := range pat.Pairs {
+			key := &object.String{Value: p.Key.Value}
+			if err := bindPattern(p.Value, hash.Pairs[key.HashKey()].Value, env); err != nil {
+				return err
+			}
+		}
+	default:
+		return newError("%s: cannot destructure into %s", pat.Pos(), pat)
+	}
+	return nil
+}
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrObj := array.(*object.Array)
-	idx := index.(*object.Integer).Value
+	idx, ok := object.ElementIndex(index.(*object.Integer).Value, len(arrObj.Elements))
+	if !ok {
+		return NULL
+	}
 
-	max := int64(len(arrObj.Elements) - 1)
+	return arrObj.Elements[idx]
+}
 
-	if idx < 0 || idx > max {
+// evalStringIndexExpression returns the character of a string at index,
+// counting in runes.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	s := str.(*object.String)
+	idx, ok := object.ElementIndex(index.(*object.Integer).Value, s.Len())
+	if !ok {
 		return NULL
 	}
 
-	return arrObj.Elements[idx]
+	return &object.String{Value: s.Substr(int(idx), int(idx)+1)}
+}
+
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+
+	for _, part := range node.Parts {
+		val := Eval(part, env)
+		if isAbrupt(val) {
+			return val
+		}
+
+		if str, ok := val.(*object.String); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString(val.Inspect())
+		}
+	}
+
+	return &object.String{Value: out.String()}
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
@@ -355,7 +1087,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 
 	for keyNode, valueNode := range node.Pairs {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
@@ -365,7 +1097,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 		}
 
 		value := Eval(valueNode, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 
@@ -2,9 +2,11 @@ package evaluator
 
 import (
 	"iscript/lexer"
+	"iscript/module"
 	"iscript/object"
 	"iscript/parser"
 	"testing"
+	"testing/fstest"
 )
 
 func TestIntEval(t *testing.T) {
@@ -19,6 +21,21 @@ func TestIntEval(t *testing.T) {
 		{"5 + 5 + 5 + 5 - 10", 10},
 		{"2 * 2 * 2 * 2 * 2", 32},
 		{"-50 + 100 + -50", 0},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 ** 0", 1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"~5", -6},
+		{"1 + 2 << 1", 6},
+		{"1 | 2 ^ 3 & 4", 3},
+		{"1234567 % 16 & 7", 7},
 	}
 
 	for _, tt := range tests {
@@ -27,6 +44,55 @@ func TestIntEval(t *testing.T) {
 	}
 }
 
+func TestFloatEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"2 == 2.0", true},
+		{"2.5 != 2.5", false},
+		{"7.5 % 2", 1.5},
+		{"2 ** -1", 0.5},
+		{"2.0 ** 3", 8.0},
+		{"1.5 <= 1.5", true},
+		{"2 >= 2.5", false},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		switch want := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, got, want)
+		case bool:
+			testBoolObj(t, got, want)
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("obj is not float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("value not as expected. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testEval(t *testing.T, input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -76,6 +142,39 @@ func TestEvalBool(t *testing.T) {
 		{"false != true", true},
 		{"(1 < 2) == true", true},
 		{"(1 < 2) == false", false},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"1 >= 2", false},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		testBoolObj(t, got, tt.want)
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"true || false", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"1 && null", false},
+		{"null || 0", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && 1()", false},
+		{"true || 1()", true},
+		{"true && false || true", true},
+		{"!(true && false)", true},
 	}
 
 	for _, tt := range tests {
@@ -129,6 +228,10 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else if (true) { 20 } else { 30 }", 20},
+		{"if (false) { 10 } else if (false) { 20 } else { 30 }", 30},
+		{"if (false) { 10 } else if (false) { 20 }", nil},
+		{"let x = 3; if (x == 1) { 10 } else if (x == 2) { 20 } else if (x == 3) { 30 } else { 40 }", 30},
 	}
 
 	for _, tt := range tests {
@@ -188,6 +291,15 @@ func TestErrorHandling(t *testing.T) {
 		{"foobar", "identifier not found: foobar"},
 		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
 		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"y = 1", "1:1: assignment to undeclared variable: y"},
+		{"q += 1", "1:1: assignment to undeclared variable: q"},
+		{"let f = fn() { z = 1 }; f()", "1:16: assignment to undeclared variable: z"},
+		{"len = 1", "1:1: cannot assign to len"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
 	}
 
 	for _, tt := range tests {
@@ -221,6 +333,532 @@ func TestLetStatement(t *testing.T) {
 	}
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let x = 1; x = x + 1; x", 2},
+		{"let a = 1; let b = 2; a = b = 5; a + b", 10},
+		{"let x = 1; (x = 7) + x", 14},
+		{"let f = fn() { let x = 1; x = x * 10; x }; f()", 10},
+		{"let f = fn(x) { x = x + 1; x }; f(1)", 2},
+		{"let g = 1; let f = fn() { g = g + 1 }; f(); f(); g", 3},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()", 3},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let a = counter(); let b = counter(); a(); a(); b()", 1},
+		{"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f()", 2},
+		{"let f = fn() { let n = 1; let get = fn() { n }; n = 5; get() }; f()", 5},
+		{"let f = fn() { let n = 0; fn() { fn() { n = n + 1; n } } }; let g = f()(); g(); g()", 2},
+		{"let mk = fn(v) { let n = v; fn() { n } }; let a = mk(1); let b = mk(2); a() + b() * 10", 21},
+		{"let f = fn() { f = 1; f }; f()", 1},
+		{"let f = fn() { let g = fn() { f = 3 }; g(); f }; f()", 3},
+		{"let g = fn() { let f = fn() { f = f + 1; f }; let r = f; f = 5; r() }; g()", 6},
+		{"fn f() { f = 2; 0 } f(); f", 2},
+		{"let f = fn(n) { if (n == 0) { f = 7; 0 } else { f(n - 1) } }; f(2); f", 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.want)
+	}
+}
+
+func TestIndexAndCompoundAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a", "[1, 5, 3]"},
+		{"let a = [1, 2, 3]; a[0] = a[1] = 9; a", "[9, 9, 3]"},
+		{"let a = [1, 2, 3]; let b = a; b[2] = 0; a", "[1, 2, 0]"},
+		{"let a = [1, 2, 3]; a[2] += 10", "13"},
+		{"let a = [[1], [2]]; a[1][0] -= 5; a[1][0]", "-3"},
+		{`let h = {}; h["k"] = 1; h["k"]`, "1"},
+		{`let h = {"k": 2}; h["k"] **= 3; h["k"]`, "8"},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x %= 4", "2"},
+		{"let x = 1; x <<= 4; x |= 1; x ^= 3; x &= 6; x >>= 1", "1"},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let mk = fn() { let n = 0; fn() { n += 1 } }; let c = mk(); c(); c()", "2"},
+		{"let i = 0; let next = fn() { i += 1; i }; let a = [0, 0, 0]; a[next()] += 7; [i, a[1]]", "[1, 7]"},
+		{"let x = 1; x += (x = 5); x", "6"},
+		{"let a = [1]; a[1] = 2", "ERROR: index out of range: 1 with length 1"},
+		{`let a = [1]; a["x"] = 2`, "ERROR: array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn() {}] = 1", "ERROR: unusable as hash key: FUNCTION"},
+		{`let s = "abc"; s[0] = "x"`, "ERROR: index assignment not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let i = 0; let sum = 0; while (i < 5) { sum += i; i += 1; } sum", 10},
+		{"let sum = 0; for (let i = 1; i <= 10; i += 1) { sum += i } sum", 55},
+		{"let n = 0; while (true) { n += 1; if (n == 7) { break; } } n", 7},
+		{"let n = 0; for (;;) { n += 1; if (n > 3) { break } } n", 4},
+		{"let sum = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } sum += i; } sum", 25},
+		{"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i % 3 != 0) { continue } sum += i } sum", 18},
+		{"let n = 0; for (let i = 0; i < 3; i += 1) { for (let j = 0; j < 10; j += 1) { if (j == 2) { break } n += 1 } } n", 6},
+		{"let f = fn(n) { let r = 1; while (n > 1) { r *= n; n -= 1 } r }; f(5)", 120},
+		{"let find = fn(a, x) { for (let i = 0; i < len(a); i += 1) { if (a[i] == x) { return i } } -1 }; find([4, 5, 6], 6) + find([1], 9)", 1},
+		{"let fs = []; for (let i = 0; i < 3; i += 1) { fs = push(fs, fn() { i }) } fs[0]()", 3},
+		{"let x = 0; while (false) { x = 1 } x", 0},
+		{"let x = 0; for (; x < 3;) { if (true) { let y = 1; x += y; } } x", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.want)
+	}
+}
+
+func TestLoopValue(t *testing.T) {
+	tests := []string{
+		"let i = 0; while (i < 2) { i += 1 }",
+		"while (true) { break }",
+		"for (let i = 0; i < 2; i += 1) { i }",
+		"for (;;) { break }",
+		"for (x in [1, 2]) { x }",
+		"1; while (false) {}",
+	}
+
+	for _, tt := range tests {
+		testNullObj(t, testEval(t, tt))
+	}
+}
+
+func TestLoopControlInExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let n = 0; for (i in [1, 2, 3]) { let x = if (i == 2) { break; }; n += 1 } n", "1"},
+		{"let s = 0; for (i in [1, 2, 3]) { s = s + if (i == 2) { continue } else { i } } s", "4"},
+		{"let f = fn(x) { x }; let s = 0; for (i in [1, 2, 3]) { s += f(match (i) { 2 => { break } _ => i }) } s", "1"},
+		{"let n = 0; let i = 0; while (true) { i += 1; let x = if (i == 2) { break; }; n += 1 } n", "1"},
+		{"let s = 0; let i = 0; while (i < 4) { i += 1; s += -if (i % 2 == 0) { continue } else { i } } s", "-4"},
+		{"let g = fn() { let x = if (true) { return 7 }; 0 }; g()", "7"},
+		{"let s = []; for (i in [1, 2, 3]) { s = push(s, [i, if (i > 1) { continue } else { i }]) } s", "[[1, 1]]"},
+		{`let s = ""; for (i in [1, 2, 3]) { s += "${i}${match (i) { 2 => { continue } _ => "," }}" } s`, "1,3,"},
+		{"let n = 0; for (i in [1, 2, 3]) { match (i) { x if (if (x == 3) { break } else { true }) => { n += x } } } n", "3"},
+		{"let h = {1: 0, 2: 0}; for (i in [1, 2]) { h[i] += if (i == 2) { break } else { 1 } } [h[1], h[2]]", "[1, 0]"},
+		{"let i = 0; let s = 0; while (i < 5000) { i += 1; s = s + if (true) { continue } else { 0 } } i", "5000"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestLoopControlErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"break; 1", "1:1: break outside loop"},
+		{"let f = fn() { continue }; while (true) { f() }", "1:16: continue outside loop"},
+		{"let n = 0; while (true) { let f = fn() { break; }; n += 1 }", "1:42: break outside loop"},
+		{"if (false) { continue }", "1:14: continue outside loop"},
+		{"for (x in [1]) { let f = fn(a = if (x) { break }) { a } }", "1:42: break outside loop"},
+	}
+
+	for _, tt := range tests {
+		err, ok := testEval(t, tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned", tt.input)
+			continue
+		}
+		if err.Message != tt.want {
+			t.Errorf("%q: wrong error message. want=%q, got=%q", tt.input, tt.want, err.Message)
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x } sum", "6"},
+		{"let sum = 0; for (i, x in [5, 6, 7]) { sum += i * x } sum", "20"},
+		{`let s = ""; for (c in "héllo") { s = c + s } s`, "olléh"},
+		{`let s = ""; for (i, c in "ab") { s += "${i}${c}" } s`, "0a1b"},
+		{`let s = ""; for (k, v in {"b": 2, "a": 1, "c": 3}) { s += "${k}${v}" } s`, "a1b2c3"},
+		{`let s = ""; for (v in {2: "x", 1: "y", true: "z"}) { s += v } s`, "zyx"},
+		{"let sum = 0; for (i in range(5)) { sum += i } sum", "10"},
+		{"let sum = 0; for (i in range(2, 11, 4)) { sum += i } sum", "18"},
+		{"let a = []; for (i in range(3, 0, -1)) { a = push(a, i) } a", "[3, 2, 1]"},
+		{"let n = 0; for (x in []) { n += 1 } n", "0"},
+		{"let sum = 0; for (x in [1, 2, 3, 4, 5]) { if (x == 2) { continue } if (x == 4) { break } sum += x } sum", "4"},
+		{"let n = 0; for (a in [1, 2]) { for (b in [10, 20]) { n += a * b } } n", "90"},
+		{"let f = fn(xs) { let m = 0; for (x in xs) { if (x > m) { m = x } } m }; f([3, 9, 2])", "9"},
+		{"let find = fn(xs, y) { for (i, x in xs) { if (x == y) { return i } } -1 }; find([7, 8, 9], 9)", "2"},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }) } fs[0]() }; f()", "2"},
+		{"for (x in 5) { x }", "ERROR: cannot iterate over INTEGER"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+type countdown struct {
+	from int64
+}
+
+func (c *countdown) Type() object.ObjectType { return "COUNTDOWN" }
+func (c *countdown) Inspect() string         { return "countdown" }
+func (c *countdown) Iter() object.Iterator   { return &countdownIter{n: c.from} }
+
+type countdownIter struct {
+	n int64
+}
+
+func (it *countdownIter) Next() (object.Object, object.Object, bool) {
+	if it.n == 0 {
+		return nil, nil, false
+	}
+	it.n--
+	return &object.Integer{Value: it.n}, &object.Integer{Value: it.n + 1}, true
+}
+
+func TestForInHostObject(t *testing.T) {
+	prog, err := parser.New(lexer.New("let s = 0; for (i, x in cd) { s = s * 10 + x } s")).ParseProgram()
+	if err != nil {
+		t.Fatalf("got err %v", err)
+	}
+
+	env := object.NewEnvironment()
+	env.Set("cd", &countdown{from: 3})
+
+	testIntegerObject(t, Eval(prog, env), 321)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", "12"},
+		{"let [a, ...rest] = [1, 2, 3]; rest", "[2, 3]"},
+		{"let [a, b, ...rest] = [1, 2]; rest", "[]"},
+		{`let {name, age: years} = {"name": "bob", "age": 30}; name + " ${years}"`, "bob 30"},
+		{`let {"first name": f} = {"first name": "ann", "x": 1}; f`, "ann"},
+		{`let [x, {y, z: [z]}] = [1, {"y": 2, "z": [3]}]; x + y + z`, "6"},
+		{"let a = 1; let b = 2; let [a, b] = [b, a]; a * 10 + b", "21"},
+		{"let f = fn(p) { let [x, y] = p; x - y }; f([5, 3])", "2"},
+		{"let f = fn(p) { let {v} = p; fn() { v } }; f({\"v\": 7})()", "7"},
+		{"let sum = fn(xs) { if (len(xs) == 0) { return 0 } let [x, ...r] = xs; x + sum(r) }; sum([1, 2, 3, 4])", "10"},
+		{"let a = [1, 2, 3]; let [x, ...r] = a; r[0] = 9; a", "[1, 2, 3]"},
+		{"let [a, b] = [1]", "ERROR: wrong number of values to destructure: want 2, got 1"},
+		{"let [a] = [1, 2]", "ERROR: wrong number of values to destructure: want 1, got 2"},
+		{"let [a, b, ...c] = [1]", "ERROR: not enough values to destructure: want at least 2, got 1"},
+		{"let [a] = 5", "ERROR: cannot destructure INTEGER as ARRAY"},
+		{"let {a} = [1]", "ERROR: cannot destructure ARRAY as HASH"},
+		{`let {a, b} = {"a": 1}`, "ERROR: missing key to destructure: b"},
+		{`let [{a}] = [{"b": 1}]`, "ERROR: missing key to destructure: a"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let f = fn(a, b = 10) { a + b }; f(1) + f(1, 2)", "14"},
+		{"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; f(1)", "[1, 2, 3]"},
+		{"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; f(1, 5)", "[1, 5, 6]"},
+		{"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; f(1, 5, 0)", "[1, 5, 0]"},
+		{"let f = fn(...xs) { xs }; f()", "[]"},
+		{"let f = fn(...xs) { xs }; f(1, 2)", "[1, 2]"},
+		{"let f = fn(a, ...xs) { [a, len(xs)] }; f(1, 2, 3)", "[1, 2]"},
+		{"let f = fn(a, b = 2, ...xs) { a + b + len(xs) }; f(1)", "3"},
+		{"let f = fn(a, b = 2, ...xs) { a + b + len(xs) }; f(1, 5, 6, 7)", "8"},
+		{"let add = fn(a, b, c) { a + b + c }; let xs = [1, 2]; add(...xs, 3)", "6"},
+		{"let add = fn(a, b, c) { a + b + c }; add(0, ...[1], ...[2])", "3"},
+		{`len(...["abc"])`, "3"},
+		{"let f = fn(...r) { r }; f(...[], 1, ...[2, 3])", "[1, 2, 3]"},
+		{"let k = 5; let f = fn(x = k) { x }; f()", "5"},
+		{"let mk = fn(d) { fn(x = d) { x } }; mk(7)()", "7"},
+		{"let f = fn(a, g = fn() { a }) { g() }; f(4)", "4"},
+		{"let sum = fn(xs, acc = 0) { if (len(xs) == 0) { return acc } sum(rest(xs), acc + first(xs)) }; sum([1, 2, 3])", "6"},
+		{"let f = fn(a, b = 1) { let c = 2; a + b + c }; f(1) + f(1, 1)", "8"},
+		{"fn(a, b = 1) { a }()", "ERROR: wrong number of args: want=1 to 2, got=0"},
+		{"fn(a, b = 1) { a }(1, 2, 3)", "ERROR: wrong number of args: want=1 to 2, got=3"},
+		{"fn(a, ...r) { a }()", "ERROR: wrong number of args: want at least 1, got=0"},
+		{"fn(a, b) { a }(...[1, 2, 3])", "ERROR: wrong number of args: want=2, got=3"},
+		{"fn(a) { a }(...5)", "ERROR: cannot spread INTEGER"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"fn add(a, b) { a + b } add(1, 2)", "3"},
+		{"let x = f(2); fn f(n) { n * 10 } x", "20"},
+		{"fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } } fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } } isEven(10)", "true"},
+		{"isOdd(7); fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }; fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }; isOdd(7)", "true"},
+		{"let f = fn(n) { fn even(n) { if (n == 0) { return true } odd(n - 1) } fn odd(n) { if (n == 0) { return false } even(n - 1) } odd(n) }; f(7)", "true"},
+		{"fn fact(n) { if (n < 2) { return 1 } n * fact(n - 1) } fact(5)", "120"},
+		{"let mk = fn(k) { fn add(x) { x + k } add }; mk(3)(4)", "7"},
+		{`fn greet(name = "you") { "hi " + name } greet()`, "hi you"},
+		{"if (true) { fn g() { 5 } g() }", "5"},
+		{"let f = fn() { let r = g(); fn g() { 8 } r }; f()", "8"},
+		{"let y = 1; fn f() { y } f()", "1"},
+		{"fn f() { y } let y = 2; f()", "2"},
+		{"let a = 1; fn inc() { a += 1; b = a * 10 } let b = 0; inc(); [a, b]", "[2, 20]"},
+		{"let g = fn() { let v = 10; fn h() { v + w } let w = 5; h() }; g()", "15"},
+		{"let g = fn() { fn h() { n += 1 } let n = 1; h(); n }; g()", "2"},
+		{"let x = 1; let f = fn() { let y = x; let x = 5; y + x }; f()", "6"},
+		{"f(); fn f() { 42 }", "42"},
+		{"fn f() { 1 }", "fn() {\n1\n}"},
+		{"fn f() { 1 } fn g() { 2 }", "fn() {\n2\n}"},
+		{"1 + 1; fn f() {} fn g() {}", "2"},
+		{"[if (true) { 1; fn g() {} }][0]", "null"},
+		{"[fn() { 1; fn g() {} }()][0]", "null"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (3) { 1 => "one", _ => "other" }`, "other"},
+		{`match (1.5) { 1 => "int", 1.5 => "float" }`, "float"},
+		{`match (1) { 1.0 => "float", 1 => "int" }`, "float"},
+		{`match (1.0) { 1 => "int", _ => "other" }`, "int"},
+		{`match (2.5) { 2 => "int", _ => "other" }`, "other"},
+		{`match (-2) { 2 => "pos", -2 => "neg" }`, "neg"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, "2"},
+		{`match (false) { true => 1, false => 2 }`, "2"},
+		{`match (null) { 0 => 1, null => 2 }`, "2"},
+		{`match (5) { 1 => 1 }`, "null"},
+		{`match (5) { n => n * 2 }`, "10"},
+		{`match ([1, 2]) { [a] => a, [a, b, c] => a, [a, b] => a + b }`, "3"},
+		{`match ([1, [2, 3]]) { [a, [b, 4]] => 0, [a, [b, c]] => a + b + c }`, "6"},
+		{`match ([1, 2, 3]) { [h, ...t] => t }`, "[2, 3]"},
+		{`match ([]) { [h, ...t] => h, [] => "empty" }`, "empty"},
+		{`match ("abc") { [a] => 1, {a} => 2, _ => 3 }`, "3"},
+		{`match ({"kind": "a", "v": 4}) { {kind: "b"} => 1, {kind: "a", v} if v > 5 => 2, {kind: "a", v} => v * 10 }`, "40"},
+		{`match ({"kind": "b"}) { {kind: "a"} => 1, {kind: "b", extra} => 2, {kind} => kind }`, "b"},
+		{`let f = fn(x) { match (x) { n if n < 0 => { -n } n => { n } } }; [f(-3), f(4)]`, "[3, 4]"},
+		{`let f = fn(x) { match (x) { [h, ...t] => f(t) + h, _ => 0 } }; f([1, 2, 3])`, "6"},
+		{`let x = 0; for (i in [1, 2, 3]) { match (i) { 2 => { break } _ => { x += i } } } x`, "1"},
+		{`let route = fn(m) { match (m) { {type: "ping"} => "pong", {type: "add", args: [a, b]} => a + b, _ => "unknown" } }; route({"type": "add", "args": [1, 2]})`, "3"},
+		{`let route = fn(m) { match (m) { {type: "ping"} => "pong", {type: "add", args: [a, b]} => a + b, _ => "unknown" } }; route({"type": "ping"}) + route({"type": "add", "args": [1]})`, "pongunknown"},
+		{`let x = 100; match (5) { x => x } + x`, "105"},
+		{`let x = 100; match (5) { x => x }; x`, "100"},
+		{`let f = fn(x) { let r = match (x + 1) { x => x * 10 }; r + x }; f(1)`, "21"},
+		{`let g = fn(v) { match (v) { [n] => fn() { n * 2 } } }; g([4])()`, "8"},
+		{`let t = 0; match (2) { n => t = n }; t`, "2"},
+		{`let g = fn() { let t = 0; let h = match (1) { n => fn() { t += n } }; h(); h(); t }; g()`, "2"},
+		{`match ([1]) { [a] if a > 1 => a, [b] => b + 1 }`, "2"},
+		{`let f = fn(v) { match (v) { [a] => 1, _ => a } }; f(5) + 1`, "ERROR: identifier not found: a"},
+		{`match (5) { zz => zz }; zz`, "ERROR: identifier not found: zz"},
+		{`match (1) { 1 => ({"kind": "a"}) }["kind"]`, "a"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let add = fn(a, b) { a + b }; 1 |> add(2) |> add(3)", "6"},
+		{"[1, 2, 3] |> push(4) |> len", "4"},
+		{"let double = fn(xs) { let out = []; for (x in xs) { out = push(out, x * 2) } out }; [1, 2, 3] |> double |> rest", "[4, 6]"},
+		{"5 |> fn(x) { x * x }", "25"},
+		{"let tail = fn(x, ...r) { r }; 1 |> tail(...[2, 3])", "[2, 3]"},
+		{"let inc = fn(x) { x + 1 }; 1 + 1 |> inc", "3"},
+		{"let mk = fn(n) { fn(x) { x * n } }; 1 + 1 |> (mk(10))", "20"},
+		{"[] |> len == 0", "true"},
+		{"[1, 2] |> len > 1", "true"},
+		{"let inc = fn(x) { x + 1 }; 1 |> inc + 1", "3"},
+		{"let f = fn(x, n) { [x, x * n] }; 2 |> f(3)[1]", "6"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`let h = {"name": "ann", "age": 3}; h.name`, "ann"},
+		{`let h = {"a": {"b": [1, 2]}}; h.a.b[1]`, "2"},
+		{`let h = {}; h.missing`, "null"},
+		{`let h = {}; h.name = "bo"; h.name`, "bo"},
+		{`let h = {"n": 1}; h.n += 2; h.n`, "3"},
+		{`let h = {"len": 4}; h.len`, "4"},
+		{`let h = {"f": fn(x) { x * 2 }}; h.f(4)`, "8"},
+		{`"abc".upper()`, "ABC"},
+		{`" Hi ".trim().lower()`, "hi"},
+		{`"a,b,c".split(",")`, "[a, b, c]"},
+		{`if ("hello".contains("ell")) { 1 } else { 2 }`, "1"},
+		{`[1, 2].push(3)`, "[1, 2, 3]"},
+		{`[1, 2, 3].rest().first()`, "2"},
+		{`[1, "a", true].join("-")`, "1-a-true"},
+		{`{"b": 2, "a": 1}.keys()`, "[a, b]"},
+		{`{"b": 2, "a": 1}.values()`, "[1, 2]"},
+		{`let h = {"a": 1}; h.has("a") && !h.has("b")`, "true"},
+		{`let up = "abc".upper; up()`, "ABC"},
+		{`"abc".upper`, "method upper of STRING"},
+		{`"x" |> "abc".contains`, "false"},
+		{`"abc".split(1)`, "ERROR: argument to `split` must be STRING, got=INTEGER"},
+		{`"abc".nope()`, "ERROR: undefined method nope for STRING"},
+		{`5.len()`, "ERROR: undefined method len for INTEGER"},
+		{`5.foo`, "ERROR: undefined method foo for INTEGER"},
+		{`let m = "abc".nope; 1`, "ERROR: undefined method nope for STRING"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	fsys := fstest.MapFS{
+		"counter.is":    {Data: []byte("let count = 0; export let next = fn() { count += 1; count };")},
+		"pair.is":       {Data: []byte(`export let [a, {b}] = [1, {"b": 2}];`)},
+		"hoist.is":      {Data: []byte("export let v = twice(2); export fn twice(x) { x * 2 }")},
+		"bump.is":       {Data: []byte("let counter = 0; export fn bump() { counter += step; counter } let step = 2;")},
+		"lib/math.is":   {Data: []byte(`let c = import("./consts.is"); export let tau = c.pi * 2;`)},
+		"lib/consts.is": {Data: []byte("export let pi = 3;")},
+		"a.is":          {Data: []byte(`import("./b.is")`)},
+		"b.is":          {Data: []byte(`import("./a.is")`)},
+		"nested.is":     {Data: []byte("if (true) { export let x = 1 }")},
+		"snapshot.is":   {Data: []byte("export let n = 0; export fn inc() { n += 1; n }")},
+	}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`let count = 100; let c = import("./counter.is"); c.next(); c.next(); count`, "100"},
+		{`let c = import("./counter.is"); c.next(); import("./counter.is").next()`, "2"},
+		{`let p = import("./pair.is"); p.a + p.b`, "3"},
+		{`import("./pair.is")["b"]`, "2"},
+		{`import("./hoist.is").v`, "4"},
+		{`let b = import("./bump.is"); b.bump(); b.bump()`, "4"},
+		{`import("./lib/math.is").tau`, "6"},
+		{`fn load() { import("./lib/consts.is") } load().pi`, "3"},
+		{`let m = import("./snapshot.is"); m.inc(); m.inc()`, "2"},
+		{`let m = import("./snapshot.is"); m.inc(); m.n`, "0"},
+		{`import("./lib/consts.is")`, "module lib/consts.is"},
+		{`import("./pair.is").c`, "ERROR: module pair.is has no export c"},
+		{`import("./counter.is").count`, "ERROR: module counter.is has no export count"},
+		{`import("./a.is")`, "ERROR: a.is: b.is: import cycle: a.is -> b.is -> a.is"},
+		{`import("./none.is")`, `ERROR: cannot import "./none.is": open none.is: file does not exist`},
+		{`import("../up.is")`, `ERROR: invalid module path "../up.is"`},
+		{`import("./nested.is")`, "ERROR: nested.is: export outside the top level of a module"},
+	}
+
+	for _, tt := range tests {
+		prog, err := parser.New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		env := object.NewModuleEnvironment(NewImporter(module.FS(fsys)), "")
+		got := Eval(prog, env)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+
+	got := testEval(t, `import("./pair.is")`)
+	if want := `ERROR: cannot import "./pair.is": no module loader`; got.Inspect() != want {
+		t.Errorf("wrong result without a loader. want=%q, got=%q", want, got.Inspect())
+	}
+}
+
+func TestRegistryImports(t *testing.T) {
+	registry := module.NewRegistry()
+	registry.Register("strings", map[string]object.Object{
+		"upper": object.Methods[object.STRING_OBJ]["upper"],
+	})
+	registry.Register("myapp/db", map[string]object.Object{"name": &object.String{Value: "user"}})
+	loader := module.Map{
+		"strings":   "export let upper = 1;",
+		"lib/db.is": `let db = import("myapp/db"); export let table = db.name + "s";`,
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`import("strings").upper("ab")`, "AB"},
+		{`import("./strings").upper`, "1"},
+		{`import("./lib/db.is").table`, "users"},
+		{`import("myapp/db")`, "module myapp/db"},
+		{`import("strings").trim`, "ERROR: module strings has no export trim"},
+	}
+
+	for _, tt := range tests {
+		prog, err := parser.New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		imp := NewImporter(loader)
+		imp.SetRegistry(registry)
+		got := Eval(prog, object.NewModuleEnvironment(imp, ""))
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; }"
 
@@ -288,6 +926,32 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"plain ${"text"}"`, "plain text"},
+		{`let name = "bob"; "hello ${name}!"`, "hello bob!"},
+		{`let items = [1, 2]; "you have ${len(items)} items"`, "you have 2 items"},
+		{`"${1 + 1} ${2.5} ${true} ${null} ${[1, "a"]}"`, "2 2.5 true null [1, a]"},
+		{`let f = fn(x) { "<${x}>" }; "${f("a")}${f("b")}"`, "<a><b>"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		str, ok := got.(*object.String)
+		if !ok {
+			t.Errorf("%s: obj is not String. got=%T (%+v)", tt.input, got, got)
+			continue
+		}
+
+		if str.Value != tt.want {
+			t.Errorf("%s: wrong value. want=%q, got=%q", tt.input, tt.want, str.Value)
+		}
+	}
+}
+
 func TestStringConcat(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -373,6 +1037,48 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestSlices(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"[1, 2, 3][:-1]", "[1, 2]"},
+		{"[1, 2, 3][-2:]", "[2, 3]"},
+		{"[1, 2, 3][:]", "[1, 2, 3]"},
+		{"[1, 2, 3][3:]", "[]"},
+		{"[1, 2, 3][1:1]", "[]"},
+		{"let a = [1, 2]; let b = a[:]; b[0] = 9; a[0]", "1"},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[2:]`, "llo"},
+		{`"hello"[:-1]`, "hell"},
+		{`"héllo"[1:2]`, "é"},
+		{`"hello"[0]`, "h"},
+		{`"hello"[-1]`, "o"},
+		{`"héllo"[1]`, "é"},
+		{`let s = "héllo"; s[len(s) - 1]`, "o"},
+		{`let s = "héllo"; s[0:len(s)]`, "héllo"},
+		{`let s = "héllo"; let out = ""; let i = 0; while (i < s.len()) { out = s[i] + out; i += 1 } out`, "olléh"},
+		{`len("héllo")`, "5"},
+		{`"hello"[5]`, "null"},
+		{"let a = [1, 2, 3]; a[-1] = 9; a", "[1, 2, 9]"},
+		{"[1, 2, 3][2:1]", "ERROR: slice bounds out of range: [2:1] with length 3"},
+		{"[1, 2, 3][:4]", "ERROR: slice bounds out of range: [:4] with length 3"},
+		{"[1, 2, 3][-4:]", "ERROR: slice bounds out of range: [-4:] with length 3"},
+		{`"abc"["a":]`, "ERROR: slice index must be INTEGER, got STRING"},
+		{"{}[1:]", "ERROR: slice operator not supported: HASH"},
+		{"[1, 2, 3][-1]", "3"},
+		{"[1, 2, 3][-4]", "null"},
+	}
+
+	for _, tt := range tests {
+		got := testEval(t, tt.input)
+		if got.Inspect() != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got.Inspect())
+		}
+	}
+}
+
 func TestHashLiteral(t *testing.T) {
 	input := `let two = "two";
 	{
@@ -446,6 +1152,18 @@ func TestHashIndex(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{1: 5}[1.0]`,
+			5,
+		},
+		{
+			`{2.0: 5}[2]`,
+			5,
+		},
+		{
+			`{1: 5}[1.5]`,
+			nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,85 @@
+package evaluator
+
+import (
+	"strings"
+
+	"iscript/ast"
+	"iscript/lexer"
+	"iscript/module"
+	"iscript/object"
+	"iscript/parser"
+)
+
+// Importer evaluates the modules imported by a program, each once. Evaluate
+// the program in an environment from object.NewModuleEnvironment to use it.
+type Importer struct {
+	loader   module.Loader
+	registry *module.Registry
+	modules  map[string]*object.Module
+	// loading holds the modules being evaluated, each imported by the one
+	// before it.
+	loading []string
+}
+
+// NewImporter returns an Importer loading modules with l, which may be nil if
+// the program only imports modules from a registry.
+func NewImporter(l module.Loader) *Importer {
+	return &Importer{loader: l, modules: map[string]*object.Module{}}
+}
+
+// SetRegistry makes the modules in r importable.
+func (imp *Importer) SetRegistry(r *module.Registry) {
+	imp.registry = r
+}
+
+func (imp *Importer) Import(from, name string) object.Object {
+	if imp.registry != nil {
+		if m, ok := imp.registry.Lookup(name); ok {
+			return m
+		}
+	}
+	if imp.loader == nil {
+		return newError("cannot import %q: no module loader", name)
+	}
+	path, err := module.Resolve(from, name)
+	if err != nil {
+		return newError("%s", err)
+	}
+	if m, ok := imp.modules[path]; ok {
+		return m
+	}
+	for i, p := range imp.loading {
+		if p == path {
+			cycle := append(append([]string{}, imp.loading[i:]...), path)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := imp.loader.Load(path)
+	if err != nil {
+		return newError("cannot import %q: %s", name, err)
+	}
+	prog, err := parser.New(lexer.New(string(src))).ParseProgram()
+	if err != nil {
+		return newError("%s: %s", path, err)
+	}
+
+	env := object.NewModuleEnvironment(imp, path)
+	imp.loading = append(imp.loading, path)
+	res := Eval(prog, env)
+	imp.loading = imp.loading[:len(imp.loading)-1]
+	if err, ok := res.(*object.Error); ok {
+		return newError("%s: %s", path, err.Message)
+	}
+
+	m := &object.Module{Path: path, Exports: map[string]object.Object{}}
+	for _, stmt := range prog.Statements {
+		if es, ok := stmt.(*ast.ExportStatement); ok {
+			for _, name := range es.Names() {
+				m.Exports[name], _ = env.Get(name)
+			}
+		}
+	}
+	imp.modules[path] = m
+	return m
+}
@@ -1,30 +1,134 @@
 package lexer
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"iscript/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// maxLookahead is how many characters past the current one the lexer may
+// peek at.
+const maxLookahead = 2
+
 type Lexer struct {
-	input        string
-	position     int
-	readPosition int
-	ch           byte
+	r    io.RuneReader
+	done bool  // r has no more input
+	err  error // first read error other than io.EOF
+
+	// ahead holds characters already read from r but not consumed yet.
+	ahead []lookahead
+
+	position     int // byte offset of ch
+	readPosition int // byte offset after ch
+	ch           rune
+	eof          bool // ch is past the end of the input
+
+	// While recording, every consumed character is appended to text. Used
+	// to collect the source of tokens spanning many characters.
+	recording bool
+	text      strings.Builder
+
+	// line and column of ch, columns count runes
+	line   int
+	column int
+
+	// interp has an entry for every ${ we are inside of, counting the
+	// braces opened within that interpolation.
+	interp []int
+}
+
+type lookahead struct {
+	ch   rune
+	size int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewReader(strings.NewReader(input))
+}
+
+// NewReader returns a lexer reading its input from r as tokens are requested.
+// Only the current token and a few characters of lookahead are held in
+// memory, so r can be arbitrarily large or a stream such as a socket.
+func NewReader(r io.Reader) *Lexer {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+
+	l := &Lexer{r: rr, line: 1}
 	l.readChar()
 	return l
 }
 
+// Err returns the first error, other than io.EOF, hit while reading the
+// input. The input is treated as ending where the error happened.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
-		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+	if l.eof {
+		return
 	}
+	if l.recording {
+		l.text.WriteRune(l.ch)
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
 	l.position = l.readPosition
-	l.readPosition += 1
+
+	if !l.fill(1) {
+		l.ch = 0
+		l.eof = true
+		return
+	}
+
+	next := l.ahead[0]
+	l.ahead = l.ahead[:copy(l.ahead, l.ahead[1:])]
+	l.ch = next.ch
+	l.readPosition += next.size
+}
+
+// fill makes sure at least n characters are in the lookahead buffer and
+// reports whether there were enough left in the input.
+func (l *Lexer) fill(n int) bool {
+	for len(l.ahead) < n {
+		if l.done {
+			return false
+		}
+
+		ch, size, err := l.r.ReadRune()
+		if err != nil {
+			l.done = true
+			if !errors.Is(err, io.EOF) {
+				l.err = err
+			}
+			return false
+		}
+		l.ahead = append(l.ahead, lookahead{ch: ch, size: size})
+	}
+	return true
+}
+
+// mark starts recording the source text at the current character.
+func (l *Lexer) mark() {
+	l.recording = true
+	l.text.Reset()
+}
+
+// marked stops recording and returns the text from the mark up to, but not
+// including, the current character.
+func (l *Lexer) marked() string {
+	l.recording = false
+	return l.text.String()
 }
 
 func (l *Lexer) skipWhiteSpace() {
@@ -33,11 +137,27 @@ func (l *Lexer) skipWhiteSpace() {
 	}
 }
 
-func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+func (l *Lexer) pos() token.Position {
+	return token.Position{Offset: l.position, Line: l.line, Column: l.column}
+}
 
+func (l *Lexer) NextToken() token.Token {
 	l.skipWhiteSpace()
 
+	start := l.pos()
+	tok := l.readToken()
+	if tok.Pos.Line == 0 {
+		// Only errors inside a token are placed by readToken.
+		tok.Pos = start
+	}
+	tok.End = l.pos()
+
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -45,6 +165,10 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			lit := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: lit}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -66,20 +190,51 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		switch l.peekChar() {
+		case '/':
+			tok.Type = token.COMMENT
+			tok.Literal = l.readLineComment()
+			return tok
+		case '*':
+			return l.readBlockComment()
+		default:
+			tok = l.readOperator(token.SLASH)
+		}
+	case '&':
+		tok = l.readOperator(token.BIT_AND)
+	case '|':
+		tok = l.readOperator(token.BIT_OR)
+	case '^':
+		tok = l.readOperator(token.BIT_XOR)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
+	case '%':
+		tok = l.readOperator(token.MOD)
 	case '*':
-		tok = newToken(token.SPLAT, l.ch)
+		tok = l.readOperator(token.SPLAT)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.readOperator(token.LT)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.readOperator(token.GT)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.readOperator(token.MINUS)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.readOperator(token.PLUS)
 	case '{':
+		if n := len(l.interp); n > 0 {
+			l.interp[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interp); n > 0 {
+			if l.interp[n-1] == 0 {
+				// This closes the interpolation, carry on with the string.
+				l.interp = l.interp[:n-1]
+				tok = l.readString(false)
+				break
+			}
+			l.interp[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
@@ -87,9 +242,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RBRACKET, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.peekCharN(2) == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.DOT, l.ch)
+		}
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(true)
+	case '`':
+		tok = l.readRawString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -99,11 +263,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdentifier(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
-			return tok
+			return l.readNumber()
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = illegal("illegal character %q", l.ch)
 		}
 	}
 
@@ -111,48 +273,392 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+// operators holds the operators longer than one character.
+var operators = map[string]token.TokenType{
+	"&&":  token.AND,
+	"||":  token.OR,
+	"|>":  token.PIPE,
+	"**":  token.POW,
+	"<=":  token.LTE,
+	">=":  token.GTE,
+	"<<":  token.SHL,
+	">>":  token.SHR,
+	"+=":  token.PLUS_ASSIGN,
+	"-=":  token.MINUS_ASSIGN,
+	"*=":  token.SPLAT_ASSIGN,
+	"/=":  token.SLASH_ASSIGN,
+	"%=":  token.MOD_ASSIGN,
+	"**=": token.POW_ASSIGN,
+	"&=":  token.BIT_AND_ASSIGN,
+	"|=":  token.BIT_OR_ASSIGN,
+	"^=":  token.BIT_XOR_ASSIGN,
+	"<<=": token.SHL_ASSIGN,
+	">>=": token.SHR_ASSIGN,
+}
+
+// readOperator reads the longest operator in operators starting at the
+// current character, or just the current character as a token of type
+// single.
+func (l *Lexer) readOperator(single token.TokenType) token.Token {
+	chars := []rune{l.ch, l.peekChar(), l.peekCharN(2)}
+	for n := len(chars); n > 1; n-- {
+		lit := string(chars[:n])
+		if tokType, ok := operators[lit]; ok {
+			for i := 1; i < n; i++ {
+				l.readChar()
+			}
+			return token.Token{Type: tokType, Literal: lit}
+		}
+	}
+	return newToken(single, l.ch)
+}
+
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
-func (l *Lexer) readChunk(f func(byte) bool) string {
-	position := l.position
-	for f(l.ch) {
+func illegal(format string, a ...interface{}) token.Token {
+	return token.Token{Type: token.ILLEGAL, Literal: fmt.Sprintf(format, a...)}
+}
+
+// illegalAt is like illegal for an error at pos, inside the token being read.
+func illegalAt(pos token.Position, format string, a ...interface{}) token.Token {
+	tok := illegal(format, a...)
+	tok.Pos = pos
+	return tok
+}
+
+func (l *Lexer) readChunk(f func(rune) bool) string {
+	var out strings.Builder
+	for !l.eof && f(l.ch) {
+		out.WriteRune(l.ch)
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return out.String()
 }
 
 func (l *Lexer) readIdentifier() string {
 	return l.readChunk(isLetter)
 }
 
-func (l *Lexer) readNumber() string {
-	return l.readChunk(isDigit)
+// readNumber reads an integer or a float. Integers may be written in hex
+// (0xFF), octal (0o755) or binary (0b1010), and any digits may be grouped
+// with underscores (1_000_000). A float needs digits on both sides of the dot
+// and may have an exponent: 3.14, 1e-9, 2.5E+3.
+//
+// The digits are not validated here; the parser reports malformed literals.
+func (l *Lexer) readNumber() token.Token {
+	l.mark()
+	tokType := token.TokenType(token.INT)
+
+	if l.ch == '0' && strings.ContainsRune("xXoObB", l.peekChar()) {
+		l.readChar()
+		l.readChar()
+		l.readChunk(isAlphanumeric)
+		return token.Token{Type: tokType, Literal: l.marked()}
+	}
+
+	l.readChunk(isDecimalDigit)
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokType = token.FLOAT
+		l.readChar()
+		l.readChunk(isDecimalDigit)
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if next == '+' || next == '-' {
+			next = l.peekCharN(2)
+		}
+		if isDigit(next) {
+			tokType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readChunk(isDecimalDigit)
+		}
+	}
+
+	return token.Token{Type: tokType, Literal: l.marked()}
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+// isDecimalDigit reports whether ch is a digit or a digit separator.
+func isDecimalDigit(ch rune) bool {
+	return isDigit(ch) || ch == '_'
+}
+
+func isAlphanumeric(ch rune) bool {
+	return isLetter(ch) || isDigit(ch)
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func (l *Lexer) peekChar() rune {
+	return l.peekCharN(1)
+}
+
+// peekCharN returns the character n positions after the current one, or 0
+// past the end of the input. n may be at most maxLookahead.
+func (l *Lexer) peekCharN(n int) rune {
+	if n > maxLookahead {
+		panic(fmt.Sprintf("lexer: lookahead of %d exceeds %d", n, maxLookahead))
+	}
+	if l.eof || !l.fill(n) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	return l.ahead[n-1].ch
 }
 
-func (l *Lexer) readString() string {
-	pos := l.position + 1
+func (l *Lexer) atEOF() bool {
+	return l.eof
+}
+
+// readString reads a double quoted string starting at the opening quote, or
+// the rest of an interpolated string starting at the } closing an
+// interpolation. It leaves the lexer on the closing quote, or on the { of the
+// next ${. The literal of the returned token is the text read with all escape
+// sequences resolved.
+func (l *Lexer) readString(start bool) token.Token {
+	var out strings.Builder
+	// The first invalid escape sequence and the position of its backslash.
+	var escErr error
+	var escPos token.Position
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+
+		switch {
+		case l.ch == 0 && l.atEOF():
+			return illegal("unterminated string")
+		case l.ch == '"':
+			if escErr != nil {
+				return illegalAt(escPos, "%s", escErr)
+			}
+			tokType := token.TokenType(token.STRING_TAIL)
+			if start {
+				tokType = token.STRING
+			}
+			return token.Token{Type: tokType, Literal: out.String()}
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.interp = append(l.interp, 0)
+			if escErr != nil {
+				return illegalAt(escPos, "%s", escErr)
+			}
+			tokType := token.TokenType(token.STRING_MIDDLE)
+			if start {
+				tokType = token.STRING_HEAD
+			}
+			return token.Token{Type: tokType, Literal: out.String()}
+		case l.ch == '\\':
+			pos := l.pos()
+			err := l.readEscape(&out)
+			if err != nil && escErr == nil {
+				escErr, escPos = err, pos
+			}
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
+}
+
+// readRawString reads a backtick delimited string starting at the opening
+// backtick and leaves the lexer on the closing one. Nothing in a raw string is
+// escaped. If the opening backtick is directly followed by a line break, that
+// line break is dropped and the string is dedented, see dedent.
+func (l *Lexer) readRawString() token.Token {
+	l.readChar()
+	l.mark()
+
+	for l.ch != '`' {
+		if l.atEOF() {
+			l.marked()
+			return illegal("unterminated raw string")
+		}
+		l.readChar()
+	}
+
+	text := l.marked()
+	if strings.HasPrefix(text, "\n") {
+		text = dedent(text[1:])
+	} else if strings.HasPrefix(text, "\r\n") {
+		text = dedent(text[2:])
+	}
+
+	return token.Token{Type: token.RAW_STRING, Literal: text}
+}
+
+// dedent removes the leading whitespace common to all non blank lines of
+// text. Blank lines are emptied. The last line is the one holding the closing
+// backtick; if it is blank its indentation counts too, so the closing
+// backtick can mark how far to dedent.
+func dedent(text string) string {
+	lines := strings.Split(text, "\n")
+
+	var prefix string
+	found := false
+	for i, line := range lines {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent == line && i != len(lines)-1 {
+			continue
+		}
+
+		if !found {
+			prefix = indent
+			found = true
+			continue
+		}
+
+		n := 0
+		for n < len(prefix) && n < len(indent) && prefix[n] == indent[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+
+	for i, line := range lines {
+		if strings.TrimLeft(line, " \t") == "" {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = line[len(prefix):]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var simpleEscapes = map[rune]rune{
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'$':  '$',
+	'\'': '\'',
+}
+
+// readEscape reads the escape sequence following a backslash and writes the
+// character it stands for to out. Supported forms are the single character
+// escapes in simpleEscapes, \xHH for the code point U+00HH, so strings stay
+// valid UTF-8, and \uHHHH or \u{H...} with one to six hex digits for a code
+// point.
+func (l *Lexer) readEscape(out *strings.Builder) error {
+	l.readChar()
+
+	if r, ok := simpleEscapes[l.ch]; ok {
+		out.WriteRune(r)
+		return nil
+	}
+
+	switch l.ch {
+	case 'x':
+		v, ok := l.readHex(2, 2)
+		if !ok {
+			return fmt.Errorf("invalid escape sequence: \\x must be followed by 2 hex digits")
+		}
+		out.WriteRune(v)
+		return nil
+	case 'u':
+		var v rune
+		var ok bool
+		if l.peekChar() == '{' {
+			l.readChar()
+			v, ok = l.readHex(1, 6)
+			ok = ok && l.peekChar() == '}'
+			if ok {
+				l.readChar()
+			}
+		} else {
+			v, ok = l.readHex(4, 4)
+		}
+		if !ok {
+			return fmt.Errorf("invalid escape sequence: \\u must be followed by 4 hex digits or {1-6 hex digits}")
+		}
+		if !utf8.ValidRune(v) {
+			return fmt.Errorf("invalid escape sequence: U+%X is not a valid code point", v)
+		}
+		out.WriteRune(v)
+		return nil
+	case 0:
+		if l.atEOF() {
+			// Reported as an unterminated string by the caller.
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid escape sequence: \\%c", l.ch)
+}
+
+// readHex reads between min and max hex digits following the current
+// character. Nothing past the last hex digit is consumed.
+func (l *Lexer) readHex(min, max int) (rune, bool) {
+	var v rune
+	n := 0
+	for n < max && isHexDigit(l.peekChar()) {
+		l.readChar()
+		v = v*16 + hexValue(l.ch)
+		n++
+	}
+	return v, n >= min
+}
+
+func hexValue(ch rune) rune {
+	switch {
+	case isDigit(ch):
+		return ch - '0'
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10
+	default:
+		return ch - 'A' + 10
+	}
+}
+
+// readLineComment reads a // comment up to, but not including, the end of
+// the line.
+func (l *Lexer) readLineComment() string {
+	return l.readChunk(func(ch rune) bool { return ch != '\n' && ch != 0 })
+}
+
+// readBlockComment reads a /* */ comment. Block comments nest, so every /*
+// inside the comment needs its own */.
+func (l *Lexer) readBlockComment() token.Token {
+	l.mark()
+	depth := 0
+
+	for {
+		switch {
+		case l.atEOF():
+			l.marked()
+			return illegal("unterminated block comment")
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return token.Token{Type: token.COMMENT, Literal: l.marked()}
+			}
 		}
+		l.readChar()
 	}
-	return l.input[pos:l.position]
 }
@@ -1,8 +1,12 @@
 package lexer
 
 import (
+	"errors"
+	"io"
 	"iscript/token"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -21,7 +25,7 @@ func TestNextToken(t *testing.T) {
 	};
 	
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 
 	if (5 < 10) {
@@ -142,3 +146,447 @@ func TestNextToken(t *testing.T) {
 		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
 	}
 }
+
+func TestLogicalOperators(t *testing.T) {
+	input := "a && b || !c && d || e"
+
+	tests := []toks{
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.BANG, "!"},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	var ret []toks
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		ret = append(ret, toks{tok.Type, tok.Literal})
+	}
+	ret = append(ret, toks{token.EOF, ""})
+	if diff := pretty.Compare(tests, ret); diff != "" {
+		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := "<= >= < > % ** * & | ^ ~ << >> += -= *= /= %= **= &= |= ^= <<= >>= ... => |> = =="
+
+	tests := []toks{
+		{token.LTE, "<="},
+		{token.GTE, ">="},
+		{token.LT, "<"},
+		{token.GT, ">"},
+		{token.MOD, "%"},
+		{token.POW, "**"},
+		{token.SPLAT, "*"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.SHL, "<<"},
+		{token.SHR, ">>"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.MINUS_ASSIGN, "-="},
+		{token.SPLAT_ASSIGN, "*="},
+		{token.SLASH_ASSIGN, "/="},
+		{token.MOD_ASSIGN, "%="},
+		{token.POW_ASSIGN, "**="},
+		{token.BIT_AND_ASSIGN, "&="},
+		{token.BIT_OR_ASSIGN, "|="},
+		{token.BIT_XOR_ASSIGN, "^="},
+		{token.SHL_ASSIGN, "<<="},
+		{token.SHR_ASSIGN, ">>="},
+		{token.ELLIPSIS, "..."},
+		{token.ARROW, "=>"},
+		{token.PIPE, "|>"},
+		{token.ASSIGN, "="},
+		{token.EQ, "=="},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	var ret []toks
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		ret = append(ret, toks{tok.Type, tok.Literal})
+	}
+	ret = append(ret, toks{token.EOF, ""})
+	if diff := pretty.Compare(tests, ret); diff != "" {
+		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestLoopKeywords(t *testing.T) {
+	input := "while for break continue in match import export whiles"
+
+	tests := []toks{
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.IN, "in"},
+		{token.MATCH, "match"},
+		{token.IMPORT, "import"},
+		{token.EXPORT, "export"},
+		{token.IDENT, "whiles"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	var ret []toks
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		ret = append(ret, toks{tok.Type, tok.Literal})
+	}
+	ret = append(ret, toks{token.EOF, ""})
+	if diff := pretty.Compare(tests, ret); diff != "" {
+		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 10;\n  x + \"ab\";"
+
+	tests := []struct {
+		expectedType token.TokenType
+		pos, end     token.Position
+	}{
+		{token.LET, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{token.SEMICOLON, token.Position{Offset: 10, Line: 1, Column: 11}, token.Position{Offset: 11, Line: 1, Column: 12}},
+		{token.IDENT, token.Position{Offset: 14, Line: 2, Column: 3}, token.Position{Offset: 15, Line: 2, Column: 4}},
+		{token.PLUS, token.Position{Offset: 16, Line: 2, Column: 5}, token.Position{Offset: 17, Line: 2, Column: 6}},
+		{token.STRING, token.Position{Offset: 18, Line: 2, Column: 7}, token.Position{Offset: 22, Line: 2, Column: 11}},
+		{token.SEMICOLON, token.Position{Offset: 22, Line: 2, Column: 11}, token.Position{Offset: 23, Line: 2, Column: 12}},
+		{token.EOF, token.Position{Offset: 23, Line: 2, Column: 12}, token.Position{Offset: 23, Line: 2, Column: 12}},
+		{token.EOF, token.Position{Offset: 23, Line: 2, Column: 12}, token.Position{Offset: 23, Line: 2, Column: 12}},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. want=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.pos {
+			t.Errorf("tests[%d] - wrong Pos. want=%#v, got=%#v", i, tt.pos, tok.Pos)
+		}
+		if tok.End != tt.end {
+			t.Errorf("tests[%d] - wrong End. want=%#v, got=%#v", i, tt.end, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing
+/* block
+   comment */ x / 2;
+/* outer /* nested */ still outer */
+/* unterminated /* nested */`
+
+	tests := []toks{
+		{token.COMMENT, "// leading comment"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// trailing"},
+		{token.COMMENT, "/* block\n   comment */"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "/* outer /* nested */ still outer */"},
+		{token.ILLEGAL, "unterminated block comment"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	var ret []toks
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		ret = append(ret, toks{tok.Type, tok.Literal})
+	}
+	ret = append(ret, toks{token.EOF, ""})
+	if diff := pretty.Compare(tests, ret); diff != "" {
+		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  token.Token
+	}{
+		{`"plain"`, token.Token{Type: token.STRING, Literal: "plain"}},
+		{`"a\nb\tc\r"`, token.Token{Type: token.STRING, Literal: "a\nb\tc\r"}},
+		{`"say \"hi\" \\ '\''"`, token.Token{Type: token.STRING, Literal: `say "hi" \ '''`}},
+		{`"\a\b\f\v\0"`, token.Token{Type: token.STRING, Literal: "\a\b\f\v\x00"}},
+		{`"\x41\x7a"`, token.Token{Type: token.STRING, Literal: "Az"}},
+		{`"\xff\xE9"`, token.Token{Type: token.STRING, Literal: "ÿé"}},
+		{`"é\u{1F600}"`, token.Token{Type: token.STRING, Literal: "é😀"}},
+		{`"héllo wörld"`, token.Token{Type: token.STRING, Literal: "héllo wörld"}},
+		{`"\q"`, token.Token{Type: token.ILLEGAL, Literal: `invalid escape sequence: \q`}},
+		{`"\x4"`, token.Token{Type: token.ILLEGAL, Literal: `invalid escape sequence: \x must be followed by 2 hex digits`}},
+		{`"\u12"`, token.Token{Type: token.ILLEGAL, Literal: `invalid escape sequence: \u must be followed by 4 hex digits or {1-6 hex digits}`}},
+		{`"\u{12"`, token.Token{Type: token.ILLEGAL, Literal: `invalid escape sequence: \u must be followed by 4 hex digits or {1-6 hex digits}`}},
+		{`"\u{110000}"`, token.Token{Type: token.ILLEGAL, Literal: `invalid escape sequence: U+110000 is not a valid code point`}},
+		{`"\uD800"`, token.Token{Type: token.ILLEGAL, Literal: `invalid escape sequence: U+D800 is not a valid code point`}},
+		{`"never closed`, token.Token{Type: token.ILLEGAL, Literal: "unterminated string"}},
+		{`"ends in escape\`, token.Token{Type: token.ILLEGAL, Literal: "unterminated string"}},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.want.Type || tok.Literal != tt.want.Literal {
+			t.Errorf("%s: wrong token. want=%s(%q), got=%s(%q)", tt.input, tt.want.Type, tt.want.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestBadEscapeResumesAfterString(t *testing.T) {
+	l := New(`"\q" + 1`)
+
+	want := []token.TokenType{token.ILLEGAL, token.PLUS, token.INT, token.EOF}
+	for i, w := range want {
+		if tok := l.NextToken(); tok.Type != w {
+			t.Errorf("token %d wrong. want=%q, got=%q", i, w, tok.Type)
+		}
+	}
+}
+
+func TestBadEscapePosition(t *testing.T) {
+	tok := New(`"ab\q"`).NextToken()
+
+	want := token.Position{Offset: 3, Line: 1, Column: 4}
+	if tok.Type != token.ILLEGAL || tok.Pos != want {
+		t.Errorf("wrong bad escape token. want=ILLEGAL at %s, got=%s at %s", want, tok.Type, tok.Pos)
+	}
+	if tok.End.Offset != 6 {
+		t.Errorf("wrong end for bad escape token. want=6, got=%d", tok.End.Offset)
+	}
+}
+
+func TestUnicodeInput(t *testing.T) {
+	input := "let café = \"ü\"; café"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLit  string
+		pos          token.Position
+	}{
+		{token.LET, "let", token.Position{Offset: 0, Line: 1, Column: 1}},
+		{token.IDENT, "café", token.Position{Offset: 4, Line: 1, Column: 5}},
+		{token.ASSIGN, "=", token.Position{Offset: 10, Line: 1, Column: 10}},
+		{token.STRING, "ü", token.Position{Offset: 12, Line: 1, Column: 12}},
+		{token.SEMICOLON, ";", token.Position{Offset: 16, Line: 1, Column: 15}},
+		{token.IDENT, "café", token.Position{Offset: 18, Line: 1, Column: 17}},
+		{token.EOF, "", token.Position{Offset: 23, Line: 1, Column: 21}},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLit {
+			t.Fatalf("tests[%d] - wrong token. want=%s(%q), got=%s(%q)", i, tt.expectedType, tt.expectedLit, tok.Type, tok.Literal)
+		}
+		if tok.Pos != tt.pos {
+			t.Errorf("tests[%d] - wrong Pos. want=%#v, got=%#v", i, tt.pos, tok.Pos)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 1e-9 2.5E+3 10e2 1.foo 7e x.5
+	0xFF 0o755 0b1010 1_000_000 1_000.5 0XdeadBEEF 0b102 0x`
+
+	tests := []toks{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.FLOAT, "10e2"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "foo"},
+		{token.INT, "7"},
+		{token.IDENT, "e"},
+		{token.IDENT, "x"},
+		{token.DOT, "."},
+		{token.INT, "5"},
+		{token.INT, "0xFF"},
+		{token.INT, "0o755"},
+		{token.INT, "0b1010"},
+		{token.INT, "1_000_000"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0XdeadBEEF"},
+		{token.INT, "0b102"},
+		{token.INT, "0x"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	var ret []toks
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		ret = append(ret, toks{tok.Type, tok.Literal})
+	}
+	ret = append(ret, toks{token.EOF, ""})
+	if diff := pretty.Compare(tests, ret); diff != "" {
+		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	input := `"hi ${name}, ${len({"a": "}"})} left\${x}" "${a}${b}"`
+
+	tests := []toks{
+		{token.STRING_HEAD, "hi "},
+		{token.IDENT, "name"},
+		{token.STRING_MIDDLE, ", "},
+		{token.IDENT, "len"},
+		{token.LPAREN, "("},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.STRING, "}"},
+		{token.RBRACE, "}"},
+		{token.RPAREN, ")"},
+		{token.STRING_TAIL, " left${x}"},
+		{token.STRING_HEAD, ""},
+		{token.IDENT, "a"},
+		{token.STRING_MIDDLE, ""},
+		{token.IDENT, "b"},
+		{token.STRING_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	var ret []toks
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		ret = append(ret, toks{tok.Type, tok.Literal})
+	}
+	ret = append(ret, toks{token.EOF, ""})
+	if diff := pretty.Compare(tests, ret); diff != "" {
+		t.Errorf("NextToken diff: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  token.Token
+	}{
+		{"`plain`", token.Token{Type: token.RAW_STRING, Literal: "plain"}},
+		{"`C:\\dir\\n ${x} \"q\"`", token.Token{Type: token.RAW_STRING, Literal: `C:\dir\n ${x} "q"`}},
+		{"`line one\n  line two`", token.Token{Type: token.RAW_STRING, Literal: "line one\n  line two"}},
+		{
+			"`\n\t\tSELECT *\n\t\t  FROM t\n\n\t\tWHERE x = 1\n\t\t`",
+			token.Token{Type: token.RAW_STRING, Literal: "SELECT *\n  FROM t\n\nWHERE x = 1\n"},
+		},
+		{
+			"`\n    {\n      \"a\": 1\n    }\n  `",
+			token.Token{Type: token.RAW_STRING, Literal: "  {\n    \"a\": 1\n  }\n"},
+		},
+		{
+			"`\n  a\n   b`",
+			token.Token{Type: token.RAW_STRING, Literal: "a\n b"},
+		},
+		{"`never closed", token.Token{Type: token.ILLEGAL, Literal: "unterminated raw string"}},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.want.Type || tok.Literal != tt.want.Literal {
+			t.Errorf("%q: wrong token. want=%s(%q), got=%s(%q)", tt.input, tt.want.Type, tt.want.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestRawStringPositions(t *testing.T) {
+	l := New("`a\nb` x")
+
+	str := l.NextToken()
+	if str.End != (token.Position{Offset: 5, Line: 2, Column: 3}) {
+		t.Errorf("wrong End for raw string. got=%#v", str.End)
+	}
+
+	ident := l.NextToken()
+	if ident.Pos != (token.Position{Offset: 6, Line: 2, Column: 4}) {
+		t.Errorf("wrong Pos after raw string. got=%#v", ident.Pos)
+	}
+}
+
+func TestNewReaderMatchesNew(t *testing.T) {
+	input := `let café = fn(x) { x * 2.5e3 }; // comment
+	/* block */ "a\tb ${café(0x1F)} c" ` + "`raw\n`" + ` {"k": [1_000]};`
+
+	want := New(input)
+	got := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+
+	for i := 0; ; i++ {
+		w := want.NextToken()
+		g := got.NextToken()
+		if g != w {
+			t.Fatalf("token %d differs. want=%#v, got=%#v", i, w, g)
+		}
+		if w.Type == token.EOF {
+			break
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestNewReaderIsIncremental(t *testing.T) {
+	input := "let x = 1;\n" + strings.Repeat("x + 1;\n", 100000)
+	cr := &countingReader{r: strings.NewReader(input)}
+	l := NewReader(iotest.OneByteReader(cr))
+
+	for i := 0; i < 4; i++ {
+		l.NextToken()
+	}
+
+	if cr.n > 16 {
+		t.Errorf("lexer read %d bytes to produce 4 tokens", cr.n)
+	}
+}
+
+func TestNewReaderError(t *testing.T) {
+	boom := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(boom))
+	l := NewReader(r)
+
+	want := []token.TokenType{token.LET, token.IDENT, token.EOF}
+	for i, w := range want {
+		if tok := l.NextToken(); tok.Type != w {
+			t.Errorf("token %d wrong. want=%q, got=%q", i, w, tok.Type)
+		}
+	}
+
+	if !errors.Is(l.Err(), boom) {
+		t.Errorf("wrong Err. want=%v, got=%v", boom, l.Err())
+	}
+}
@@ -0,0 +1,64 @@
+// Package module finds the source of the modules a script imports.
+package module
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// A Loader returns the source of the module at a resolved path, see Resolve.
+type Loader interface {
+	Load(path string) ([]byte, error)
+}
+
+// FS loads modules from a file system, such as an embed.FS.
+func FS(fsys fs.FS) Loader {
+	return fsLoader{fsys}
+}
+
+// Dir loads modules from the files under dir.
+func Dir(dir string) Loader {
+	return FS(os.DirFS(dir))
+}
+
+type fsLoader struct {
+	fsys fs.FS
+}
+
+func (l fsLoader) Load(path string) ([]byte, error) {
+	return fs.ReadFile(l.fsys, path)
+}
+
+// Map loads modules from memory. It maps resolved paths to sources.
+type Map map[string]string
+
+func (m Map) Load(path string) ([]byte, error) {
+	src, ok := m[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return []byte(src), nil
+}
+
+// IsRelative reports whether name is imported relative to the importing
+// module, that is whether it starts with ./ or ../.
+func IsRelative(name string) bool {
+	return strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../")
+}
+
+// Resolve returns the path of the module imported as name by the module at
+// from. Relative names are resolved against the directory of from, others
+// against the root of the loader. The main program has the path "".
+func Resolve(from, name string) (string, error) {
+	p := name
+	if IsRelative(name) {
+		p = path.Join(path.Dir(from), name)
+	}
+	if !fs.ValidPath(p) || p == "." {
+		return "", fmt.Errorf("invalid module path %q", name)
+	}
+	return p, nil
+}
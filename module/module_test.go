@@ -0,0 +1,94 @@
+package module
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"iscript/object"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		from, name string
+		want       string
+		wantErr    bool
+	}{
+		{"", "./util.is", "util.is", false},
+		{"", "lib/util.is", "lib/util.is", false},
+		{"lib/a.is", "./b.is", "lib/b.is", false},
+		{"lib/a.is", "../b.is", "b.is", false},
+		{"lib/a.is", "b.is", "b.is", false},
+		{"lib/a/b.is", "./../c/./d.is", "lib/c/d.is", false},
+		{"", "../up.is", "", true},
+		{"lib/a.is", "../../up.is", "", true},
+		{"", "/abs.is", "", true},
+		{"", "./", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := Resolve(tt.from, tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q, %q): wrong error: %v", tt.from, tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q, %q): want=%q, got=%q", tt.from, tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestLoaders(t *testing.T) {
+	loaders := map[string]Loader{
+		"Map": Map{"lib/a.is": "let a = 1;"},
+		"FS":  FS(fstest.MapFS{"lib/a.is": {Data: []byte("let a = 1;")}}),
+	}
+
+	for name, l := range loaders {
+		src, err := l.Load("lib/a.is")
+		if err != nil || string(src) != "let a = 1;" {
+			t.Errorf("%s: wrong source. got=%q, err=%v", name, src, err)
+		}
+		_, err = l.Load("lib/b.is")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: want a not exist error, got %v", name, err)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	exports := map[string]object.Object{"answer": &object.Integer{Value: 42}}
+	if err := r.Register("myapp/db", exports); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	exports["other"] = &object.Integer{Value: 1}
+
+	m, ok := r.Lookup("myapp/db")
+	if !ok {
+		t.Fatalf("myapp/db not found")
+	}
+	if m.Path != "myapp/db" || len(m.Exports) != 1 || m.Exports["answer"].Inspect() != "42" {
+		t.Errorf("wrong module. got path=%q, exports=%v", m.Path, m.Exports)
+	}
+	if _, ok := r.Lookup("./myapp/db"); ok {
+		t.Errorf("relative name found a registered module")
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"myapp/db", `module "myapp/db" already registered`},
+		{"./strings", `invalid module name "./strings"`},
+		{"/strings", `invalid module name "/strings"`},
+		{"", `invalid module name ""`},
+	}
+	for _, tt := range tests {
+		err := r.Register(tt.name, nil)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Register(%q): want error %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
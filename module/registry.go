@@ -0,0 +1,49 @@
+package module
+
+import (
+	"fmt"
+	"io/fs"
+
+	"iscript/object"
+)
+
+// Registry holds modules implemented in Go, which scripts import by the name
+// they were registered under, import("strings"). A name that starts with ./
+// or ../ always refers to a file, other names refer to a registered module
+// before a file.
+type Registry struct {
+	modules map[string]*object.Module
+}
+
+func NewRegistry() *Registry {
+	return &Registry{modules: map[string]*object.Module{}}
+}
+
+// Register makes exports importable as the module name, such as "strings" or
+// "myapp/db". The exports are usually *object.Builtin functions. Every import
+// of the module gets the same exports, so they are shared by all the scripts
+// using r.
+func (r *Registry) Register(name string, exports map[string]object.Object) error {
+	if IsRelative(name) || !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid module name %q", name)
+	}
+	if _, ok := r.modules[name]; ok {
+		return fmt.Errorf("module %q already registered", name)
+	}
+
+	m := &object.Module{Path: name, Exports: make(map[string]object.Object, len(exports))}
+	for k, v := range exports {
+		m.Exports[k] = v
+	}
+	r.modules[name] = m
+	return nil
+}
+
+// Lookup returns the module imported as name, if name is a registered module.
+func (r *Registry) Lookup(name string) (*object.Module, bool) {
+	if IsRelative(name) {
+		return nil, false
+	}
+	m, ok := r.modules[name]
+	return m, ok
+}
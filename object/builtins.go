@@ -17,7 +17,7 @@ var Builtins = []struct {
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return &Integer{Value: int64(arg.Len())}
 			default:
 				return newError("argument to `len` not supported, got=%s", args[0].Type())
 			}
@@ -129,6 +129,34 @@ var Builtins = []struct {
 			return nil
 		}},
 	},
+	{
+		"range",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of args: got=%d, want=1 to 3", len(args))
+			}
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				n, ok := arg.(*Integer)
+				if !ok {
+					return newError("argument %d to `range` must be INTEGER, got=%s", i+1, arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+
+			r := &Range{End: bounds[0], Step: 1}
+			if len(bounds) > 1 {
+				r.Start, r.End = bounds[0], bounds[1]
+			}
+			if len(bounds) > 2 {
+				r.Step = bounds[2]
+			}
+			if r.Step == 0 {
+				return newError("`range` step must not be 0")
+			}
+			return r
+		}},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
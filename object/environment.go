@@ -1,8 +1,38 @@
 package object
 
+import "fmt"
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	// importer loads the modules imported by code evaluated in the
+	// environment, which belongs to the module at path.
+	importer Importer
+	path     string
+}
+
+// An Importer returns the module imported as name by the module at from, or
+// an *Error.
+type Importer interface {
+	Import(from, name string) Object
+}
+
+// NewModuleEnvironment returns the environment of the top level of the module
+// at path, which imports modules with imp.
+func NewModuleEnvironment(imp Importer, path string) *Environment {
+	env := NewEnvironment()
+	env.importer = imp
+	env.path = path
+	return env
+}
+
+// Import returns the module imported as name by the module e belongs to.
+func (e *Environment) Import(name string) Object {
+	if e.importer == nil {
+		return &Error{Message: fmt.Sprintf("cannot import %q: no module loader", name)}
+	}
+	return e.importer.Import(e.path, name)
 }
 
 func NewEnvironment() *Environment {
@@ -23,8 +53,24 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// Assign changes the value of the existing binding of name, in e or the
+// nearest enclosing environment that has it. It reports whether there was
+// such a binding.
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.importer = outer.importer
+	env.path = outer.path
 	return env
 }
@@ -0,0 +1,137 @@
+package object
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// Iterable is implemented by objects that a for-in loop can iterate over.
+// Objects defined by the host program become iterable by implementing it.
+type Iterable interface {
+	Object
+	Iter() Iterator
+}
+
+// Iterator steps through the elements of an Iterable. Next returns the key and
+// value of the next element, or false once there are none left. A for-in loop
+// with a single variable binds only the value.
+type Iterator interface {
+	Next() (key, value Object, ok bool)
+}
+
+func (a *Array) Iter() Iterator { return &arrayIterator{arr: a} }
+
+type arrayIterator struct {
+	arr *Array
+	i   int
+}
+
+func (it *arrayIterator) Next() (Object, Object, bool) {
+	if it.i >= len(it.arr.Elements) {
+		return nil, nil, false
+	}
+	key := &Integer{Value: int64(it.i)}
+	val := it.arr.Elements[it.i]
+	it.i++
+	return key, val, true
+}
+
+// Iter steps through the characters of s, keyed by character index.
+func (s *String) Iter() Iterator { return &stringIterator{s: s.Value} }
+
+type stringIterator struct {
+	s      string
+	offset int
+	i      int64
+}
+
+func (it *stringIterator) Next() (Object, Object, bool) {
+	if it.offset >= len(it.s) {
+		return nil, nil, false
+	}
+	_, size := utf8.DecodeRuneInString(it.s[it.offset:])
+	key := &Integer{Value: it.i}
+	val := &String{Value: it.s[it.offset : it.offset+size]}
+	it.offset += size
+	it.i++
+	return key, val, true
+}
+
+// Iter steps through the pairs of h in key order: booleans, then numbers, then
+// strings. Pairs added while iterating are not visited.
+func (h *Hash) Iter() Iterator {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, p := range h.Pairs {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return keyLess(pairs[i].Key, pairs[j].Key)
+	})
+	return &hashIterator{pairs: pairs}
+}
+
+type hashIterator struct {
+	pairs []HashPair
+}
+
+func (it *hashIterator) Next() (Object, Object, bool) {
+	if len(it.pairs) == 0 {
+		return nil, nil, false
+	}
+	p := it.pairs[0]
+	it.pairs = it.pairs[1:]
+	return p.Key, p.Value, true
+}
+
+func keyLess(a, b Object) bool {
+	ra, rb := keyRank(a), keyRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+
+	switch a := a.(type) {
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	default:
+		return keyNumber(a) < keyNumber(b)
+	}
+}
+
+func keyRank(o Object) int {
+	switch o.(type) {
+	case *Boolean:
+		return 0
+	case *Integer, *Float:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func keyNumber(o Object) float64 {
+	if i, ok := o.(*Integer); ok {
+		return float64(i.Value)
+	}
+	return o.(*Float).Value
+}
+
+func (r *Range) Iter() Iterator { return &rangeIterator{r: r, next: r.Start} }
+
+type rangeIterator struct {
+	r    *Range
+	next int64
+	i    int64
+}
+
+func (it *rangeIterator) Next() (Object, Object, bool) {
+	if it.r.Step > 0 && it.next >= it.r.End || it.r.Step < 0 && it.next <= it.r.End {
+		return nil, nil, false
+	}
+	key := &Integer{Value: it.i}
+	val := &Integer{Value: it.next}
+	it.next += it.r.Step
+	it.i++
+	return key, val, true
+}
@@ -0,0 +1,118 @@
+package object
+
+import "strings"
+
+// Methods holds the methods of each type, called as value.name(args). A
+// method is a builtin that gets the value it is called on as its first
+// argument.
+var Methods = map[ObjectType]map[string]*Builtin{
+	STRING_OBJ: {
+		"len": builtinMethod("len", 0),
+		"upper": method(0, func(s Object, args ...Object) Object {
+			return &String{Value: strings.ToUpper(s.(*String).Value)}
+		}),
+		"lower": method(0, func(s Object, args ...Object) Object {
+			return &String{Value: strings.ToLower(s.(*String).Value)}
+		}),
+		"trim": method(0, func(s Object, args ...Object) Object {
+			return &String{Value: strings.TrimSpace(s.(*String).Value)}
+		}),
+		"split": method(1, func(s Object, args ...Object) Object {
+			sep, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `split` must be STRING, got=%s", args[0].Type())
+			}
+			parts := strings.Split(s.(*String).Value, sep.Value)
+			elements := make([]Object, len(parts))
+			for i, p := range parts {
+				elements[i] = &String{Value: p}
+			}
+			return &Array{Elements: elements}
+		}),
+		"contains": method(1, func(s Object, args ...Object) Object {
+			sub, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `contains` must be STRING, got=%s", args[0].Type())
+			}
+			return nativeBool(strings.Contains(s.(*String).Value, sub.Value))
+		}),
+	},
+	ARRAY_OBJ: {
+		"len":   builtinMethod("len", 0),
+		"first": builtinMethod("first", 0),
+		"last":  builtinMethod("last", 0),
+		"rest":  builtinMethod("rest", 0),
+		"push":  builtinMethod("push", 1),
+		"join": method(1, func(a Object, args ...Object) Object {
+			sep, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `join` must be STRING, got=%s", args[0].Type())
+			}
+			parts := []string{}
+			for _, el := range a.(*Array).Elements {
+				parts = append(parts, el.Inspect())
+			}
+			return &String{Value: strings.Join(parts, sep.Value)}
+		}),
+	},
+	HASH_OBJ: {
+		"keys": method(0, func(h Object, args ...Object) Object {
+			keys := []Object{}
+			for it := h.(*Hash).Iter(); ; {
+				k, _, ok := it.Next()
+				if !ok {
+					return &Array{Elements: keys}
+				}
+				keys = append(keys, k)
+			}
+		}),
+		"values": method(0, func(h Object, args ...Object) Object {
+			values := []Object{}
+			for it := h.(*Hash).Iter(); ; {
+				_, v, ok := it.Next()
+				if !ok {
+					return &Array{Elements: values}
+				}
+				values = append(values, v)
+			}
+		}),
+		"has": method(1, func(h Object, args ...Object) Object {
+			key, ok := args[0].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[0].Type())
+			}
+			_, ok = h.(*Hash).Pairs[key.HashKey()]
+			return nativeBool(ok)
+		}),
+	},
+}
+
+// LookupMethod returns the method name of obj's type, or nil if it has none.
+func LookupMethod(obj Object, name string) *Builtin {
+	return Methods[obj.Type()][name]
+}
+
+// method makes a method taking numArgs arguments after the value it is called
+// on.
+func method(numArgs int, fn func(recv Object, args ...Object) Object) *Builtin {
+	return &Builtin{Fn: func(args ...Object) Object {
+		if len(args)-1 != numArgs {
+			return newError("wrong number of args: got=%d, want=%d", len(args)-1, numArgs)
+		}
+		return fn(args[0], args[1:]...)
+	}}
+}
+
+// builtinMethod makes a method of the builtin function name.
+func builtinMethod(name string, numArgs int) *Builtin {
+	return method(numArgs, func(recv Object, args ...Object) Object {
+		return GetBuiltinByName(name).Fn(append([]Object{recv}, args...)...)
+	})
+}
+
+func nativeBool(b bool) *Boolean {
+	if b {
+		return True
+	}
+	return False
+}
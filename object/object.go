@@ -6,13 +6,17 @@ import (
 	"hash/fnv"
 	"iscript/ast"
 	"iscript/code"
+	"math"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ       = "INTEGER"
+	FLOAT_OBJ         = "FLOAT"
 	BOOLEAN_OBJ       = "BOOLEAN"
 	NULL_OBJ          = "NULL"
 	RETURN_VALUE_OBJ  = "RETURN_VALUE"
@@ -24,6 +28,12 @@ const (
 	HASH_OBJ          = "HASH"
 	COMPILED_FUNC_OBJ = "COMPILED_FUNC_OBJ"
 	CLOSURE_OBJ       = "CLOSURE_OBJ"
+	CELL_OBJ          = "CELL"
+	LOOP_CONTROL_OBJ  = "LOOP_CONTROL"
+	RANGE_OBJ         = "RANGE"
+	BOUND_METHOD_OBJ  = "BOUND_METHOD"
+	MODULE_OBJ        = "MODULE"
+	COMPILED_MOD_OBJ  = "COMPILED_MOD_OBJ"
 )
 
 type Object interface {
@@ -41,6 +51,36 @@ func (i *Integer) Inspect() string {
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+type Float struct {
+	Value float64
+}
+
+// Inspect always shows a float as one, so 2.0 does not print like the
+// integer 2.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+// IsNumber reports whether obj is an integer or a float.
+func IsNumber(obj Object) bool {
+	t := obj.Type()
+	return t == INTEGER_OBJ || t == FLOAT_OBJ
+}
+
+// ToFloat widens an integer or float object to a float64.
+func ToFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*Float).Value
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -48,6 +88,12 @@ type Boolean struct {
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 
+// True and False are the only booleans, both engines compare them by identity.
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+)
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -60,6 +106,21 @@ type ReturnValue struct {
 func (r *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (r *ReturnValue) Inspect() string  { return r.Value.Inspect() }
 
+// LoopControl is the result of a break or continue statement in the
+// evaluator. Like ReturnValue it unwinds the statements enclosing it, up to the
+// nearest loop.
+type LoopControl struct {
+	Break bool
+}
+
+func (l *LoopControl) Type() ObjectType { return LOOP_CONTROL_OBJ }
+func (l *LoopControl) Inspect() string {
+	if l.Break {
+		return "break"
+	}
+	return "continue"
+}
+
 type Error struct {
 	Message string
 }
@@ -69,6 +130,8 @@ func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
 type Function struct {
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression
+	Rest       *ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -78,8 +141,15 @@ func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
+	for i, p := range f.Parameters {
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+f.Defaults[i].String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
 	}
 
 	out.WriteString("fn")
@@ -99,6 +169,31 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// Len returns the length of s in runes, the unit strings are indexed and
+// sliced in.
+func (s *String) Len() int {
+	return utf8.RuneCountInString(s.Value)
+}
+
+// Substr returns the runes of s from lo up to hi. It decodes s without keeping
+// the result, since a String constant is shared by every VM running its
+// bytecode.
+func (s *String) Substr(lo, hi int) string {
+	start, end := len(s.Value), len(s.Value)
+	n := 0
+	for i := range s.Value {
+		if n == lo {
+			start = i
+		}
+		if n == hi {
+			end = i
+			break
+		}
+		n++
+	}
+	return s.Value[start:end]
+}
+
 type BuiltInFunction func(args ...Object) Object
 
 type Builtin struct {
@@ -108,6 +203,44 @@ type Builtin struct {
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// BoundMethod is value.name read from a value that has no such field. Calling
+// it calls the method name of the value's type, see Methods.
+type BoundMethod struct {
+	Receiver Object
+	Name     string
+}
+
+func (m *BoundMethod) Type() ObjectType { return BOUND_METHOD_OBJ }
+func (m *BoundMethod) Inspect() string {
+	return fmt.Sprintf("method %s of %s", m.Name, m.Receiver.Type())
+}
+
+// Module is an imported module. Its fields are the names it exports.
+// Exports holds the values the exported variables had when the module finished
+// running: a function of the module that assigns to one later does not change
+// it.
+type Module struct {
+	Path    string
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "module " + m.Path }
+
+// CompiledModule is the code of a module. Running Fn with NumGlobals fresh
+// globals sets the global at Exports[name] to the value of each export.
+type CompiledModule struct {
+	Path       string
+	Fn         *CompiledFunc
+	NumGlobals int
+	Exports    map[string]int
+}
+
+func (cm *CompiledModule) Type() ObjectType { return COMPILED_MOD_OBJ }
+func (cm *CompiledModule) Inspect() string {
+	return fmt.Sprintf("CompiledModule[%s]", cm.Path)
+}
+
 type Array struct {
 	Elements []Object
 }
@@ -145,6 +278,17 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey gives a float with an integer value the key of that integer, since
+// they are equal, so {1: "a"}[1.0] finds "a".
+func (f *Float) HashKey() HashKey {
+	v := f.Value
+	if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+		// This includes -0, which is the same key as 0.
+		return (&Integer{Value: int64(v)}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(v)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -177,6 +321,20 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Range is the sequence of integers from Start up to, but not including, End,
+// counting by Step.
+type Range struct {
+	Start, End, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
@@ -184,9 +342,24 @@ type Hashable interface {
 type CompiledFunc struct {
 	Instructions code.Instructions
 	NumLocals    int
-	NumParams    int
+	// NumParams counts the named parameters, but not a rest parameter, which
+	// takes the local after them.
+	NumParams int
+	// MinArity and MaxArity bound the number of arguments the function takes.
+	// MaxArity is -1 when a rest parameter takes any number of extra ones.
+	MinArity int
+	MaxArity int
+	// Defaults holds the offsets of the code setting the default value of
+	// each parameter from MinArity on. The function starts with a jump over
+	// that code; a call leaving out parameters starts at the default of the
+	// first one left out instead, which falls through to the other defaults
+	// and then into the body.
+	Defaults []int
 }
 
+// Variadic reports whether the function has a rest parameter.
+func (cf *CompiledFunc) Variadic() bool { return cf.MaxArity < 0 }
+
 func (cf *CompiledFunc) Type() ObjectType { return COMPILED_FUNC_OBJ }
 func (cf *CompiledFunc) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
@@ -195,9 +368,22 @@ func (cf *CompiledFunc) Inspect() string {
 type Closure struct {
 	Fn   *CompiledFunc
 	Free []Object
+	// Globals holds the globals of the module the closure was created in.
+	Globals []Object
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
+
+// Cell holds a local variable captured by a closure, so that the function
+// defining the variable and every closure capturing it share one value. Cells
+// only live in the VM's local slots and closures' free variables; they are
+// never handed to scripts.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
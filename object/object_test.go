@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	h1 := &String{Value: "Hello World"}
@@ -21,3 +25,172 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("non matching strings have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	tests := []struct {
+		f    float64
+		i    int64
+		same bool
+	}{
+		{1.0, 1, true},
+		{-3.0, -3, true},
+		{0.0, 0, true},
+		{math.Copysign(0, -1), 0, true},
+		{1.5, 1, false},
+		{1e300, 0, false},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.f}
+		i := &Integer{Value: tt.i}
+		if same := f.HashKey() == i.HashKey(); same != tt.same {
+			t.Errorf("%g and %d: same hash key = %t, want %t", tt.f, tt.i, same, tt.same)
+		}
+	}
+}
+
+func TestHashIterOrder(t *testing.T) {
+	h := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, k := range []Object{
+		&String{Value: "b"},
+		&Integer{Value: 10},
+		&Boolean{Value: true},
+		&Float{Value: 2.5},
+		&String{Value: "a"},
+		&Integer{Value: -1},
+		&Boolean{Value: false},
+	} {
+		h.Pairs[k.(Hashable).HashKey()] = HashPair{Key: k, Value: k}
+	}
+
+	var got []string
+	it := h.Iter()
+	for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+		got = append(got, k.Inspect())
+	}
+
+	want := []string{"false", "true", "-1", "2.5", "10", "a", "b"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("wrong iteration order. want=%v, got=%v", want, got)
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{2, "2.0"},
+		{3.14, "3.14"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if got := f.Inspect(); got != tt.want {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestStringRunes(t *testing.T) {
+	tests := []struct {
+		value  string
+		length int
+		substr string
+	}{
+		{"hello", 5, "el"},
+		{"héllo", 5, "él"},
+		{"", 0, ""},
+	}
+
+	for _, tt := range tests {
+		s := &String{Value: tt.value}
+		if s.Len() != tt.length {
+			t.Errorf("%q: wrong length. want=%d, got=%d", tt.value, tt.length, s.Len())
+		}
+		if tt.length >= 3 {
+			if got := s.Substr(1, 3); got != tt.substr {
+				t.Errorf("%q: wrong substring. want=%q, got=%q", tt.value, tt.substr, got)
+			}
+		}
+	}
+}
+
+func TestIntPow(t *testing.T) {
+	tests := []struct {
+		base, exp, want int64
+	}{
+		{2, 10, 1024},
+		{-3, 3, -27},
+		{7, 0, 1},
+		{0, 0, 1},
+		{2, 64, 0},
+	}
+
+	for _, tt := range tests {
+		if got := IntPow(tt.base, tt.exp); got != tt.want {
+			t.Errorf("IntPow(%d, %d) = %d, want %d", tt.base, tt.exp, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesValue(t *testing.T) {
+	tests := []struct {
+		pattern, val Object
+		want         bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Float{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Integer{Value: 1}, &Float{Value: 1.5}, false},
+		{&Integer{Value: 1}, &String{Value: "1"}, false},
+		{True, True, true},
+		{True, False, false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchesValue(tt.pattern, tt.val); got != tt.want {
+			t.Errorf("MatchesValue(%s, %s) = %t, want %t", tt.pattern.Inspect(), tt.val.Inspect(), got, tt.want)
+		}
+	}
+}
+
+func TestSliceBounds(t *testing.T) {
+	null := &Null{}
+	tests := []struct {
+		low, high Object
+		lo, hi    int64
+		err       string
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 3}, 1, 3, ""},
+		{null, &Integer{Value: -1}, 0, 4, ""},
+		{&Integer{Value: -2}, null, 3, 5, ""},
+		{&Integer{Value: 3}, &Integer{Value: 2}, 0, 0, "slice bounds out of range: [3:2] with length 5"},
+		{null, &Integer{Value: 6}, 0, 0, "slice bounds out of range: [:6] with length 5"},
+		{&String{Value: "a"}, null, 0, 0, "slice index must be INTEGER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		lo, hi, err := SliceBounds(tt.low, tt.high, 5)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("[%s:%s]: wrong error. want=%q, got=%v", sliceBound(tt.low), sliceBound(tt.high), tt.err, err)
+			}
+			continue
+		}
+		if err != nil || lo != tt.lo || hi != tt.hi {
+			t.Errorf("[%s:%s]: want %d, %d, got %d, %d, %v", sliceBound(tt.low), sliceBound(tt.high), tt.lo, tt.hi, lo, hi, err)
+		}
+	}
+
+	if i, ok := ElementIndex(-1, 3); !ok || i != 2 {
+		t.Errorf("ElementIndex(-1, 3) = %d, %t, want 2, true", i, ok)
+	}
+	if _, ok := ElementIndex(3, 3); ok {
+		t.Errorf("ElementIndex(3, 3) is in range")
+	}
+}
@@ -0,0 +1,78 @@
+package object
+
+import "fmt"
+
+// IntPow raises base to the power exp, which must not be negative. Like the
+// other integer operators it wraps around on overflow.
+func IntPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// MatchesValue reports whether val matches the literal pattern of a match
+// arm, which it does when they are equal. Like ==, an integer and a float are
+// compared as numbers, and other values only match values of their own type.
+func MatchesValue(pattern, val Object) bool {
+	if IsNumber(pattern) && IsNumber(val) && pattern.Type() != val.Type() {
+		return ToFloat(pattern) == ToFloat(val)
+	}
+	if pattern.Type() != val.Type() {
+		return false
+	}
+	p, ok := pattern.(Hashable)
+	v, vok := val.(Hashable)
+	if !ok || !vok {
+		return pattern == val
+	}
+	return p.HashKey() == v.HashKey()
+}
+
+// ElementIndex resolves an index into something of the given length, where a
+// negative index counts from the end. It reports false if it is out of range.
+func ElementIndex(i int64, length int) (int64, bool) {
+	if i < 0 {
+		i += int64(length)
+	}
+	return i, i >= 0 && i < int64(length)
+}
+
+// SliceBounds resolves the bounds of a slice of something of the given
+// length. A negative bound counts from the end, and a null one stands for the
+// start or end.
+func SliceBounds(low, high Object, length int) (int64, int64, error) {
+	bounds := [2]int64{0, int64(length)}
+	for i, b := range []Object{low, high} {
+		switch b := b.(type) {
+		case *Integer:
+			bounds[i] = b.Value
+			if b.Value < 0 {
+				bounds[i] += int64(length)
+			}
+		case *Null:
+		default:
+			return 0, 0, fmt.Errorf("slice index must be INTEGER, got %s", b.Type())
+		}
+	}
+
+	lo, hi := bounds[0], bounds[1]
+	if lo < 0 || hi > int64(length) || lo > hi {
+		return 0, 0, fmt.Errorf("slice bounds out of range: [%s:%s] with length %d",
+			sliceBound(low), sliceBound(high), length)
+	}
+	return lo, hi, nil
+}
+
+// sliceBound formats a bound of a slice as it was written.
+func sliceBound(b Object) string {
+	if _, ok := b.(*Null); ok {
+		return ""
+	}
+	return b.Inspect()
+}
@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"fmt"
+	"iscript/token"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Error is a syntax error found by the parser.
+type Error struct {
+	Pos token.Position
+	// Expected lists the tokens that would have been valid at Pos. It is
+	// empty when the problem is not a missing token, such as an integer
+	// literal that is out of range.
+	Expected []token.TokenType
+	Msg      string
+	// Err is the error reading the input, if that is what went wrong.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// errorf records a syntax error. Once a statement has an error, any further
+// errors in it are dropped until the parser has skipped to the next statement,
+// since they are almost always caused by the first one.
+func (p *Parser) errorf(pos token.Position, expected []token.TokenType, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.addError(&Error{Pos: pos, Expected: expected, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (p *Parser) addError(err *Error) {
+	p.errors = multierror.Append(p.errors, err)
+}
+
+// Errors returns the syntax errors found so far, in the order they were found.
+func (p *Parser) Errors() []*Error {
+	var errs []*Error
+	for _, err := range p.errors.Errors {
+		if e, ok := err.(*Error); ok {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// synchronize skips the rest of a statement with a syntax error. start is the
+// bracket depth at the start of the statement. It stops on the statement's
+// semicolon, or before a token that can only start a new statement or close
+// the enclosing block. Brackets the statement opened, before or after the
+// error, are skipped as a whole, and so is a stray } outside any block. A
+// semicolon can only be inside braces, so one directly inside an unclosed (
+// or [ ends the statement too.
+func (p *Parser) synchronize(start int) {
+	for !p.curTokenIs(token.EOF) {
+		depth := len(p.brackets) - start
+		if p.curTokenIs(token.SEMICOLON) && (depth <= 0 || p.brackets[len(p.brackets)-1] != token.LBRACE) {
+			break
+		}
+		if depth <= 0 && statementEnd[p.peekToken.Type] && (start > 0 || !p.peekTokenIs(token.RBRACE)) {
+			break
+		}
+		p.nextToken()
+
+		if p.curTokenIs(token.ILLEGAL) {
+			// Lexer errors are not caused by the syntax error, keep them.
+			p.addError(&Error{Pos: p.curToken.Pos, Msg: p.curToken.Literal})
+		}
+	}
+
+	// Forget the brackets the statement left open.
+	if len(p.brackets) > start {
+		p.brackets = p.brackets[:start]
+	}
+}
+
+// statementEnd holds the tokens that end a statement being skipped when they
+// follow it.
+var statementEnd = map[token.TokenType]bool{
+	token.EOF:      true,
+	token.RBRACE:   true,
+	token.LET:      true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.EXPORT:   true,
+}
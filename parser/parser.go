@@ -1,11 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"iscript/ast"
 	"iscript/lexer"
 	"iscript/token"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/hashicorp/go-multierror"
 )
@@ -13,26 +16,60 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // < >
+	PIPE        // |>
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
+	SHIFT       // << >>
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // **
 	CALL        // myFunc
 	INDEX       // array[index]
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NEQ:      EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.SPLAT:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:         ASSIGN,
+	token.PLUS_ASSIGN:    ASSIGN,
+	token.MINUS_ASSIGN:   ASSIGN,
+	token.SPLAT_ASSIGN:   ASSIGN,
+	token.SLASH_ASSIGN:   ASSIGN,
+	token.MOD_ASSIGN:     ASSIGN,
+	token.POW_ASSIGN:     ASSIGN,
+	token.BIT_AND_ASSIGN: ASSIGN,
+	token.BIT_OR_ASSIGN:  ASSIGN,
+	token.BIT_XOR_ASSIGN: ASSIGN,
+	token.SHL_ASSIGN:     ASSIGN,
+	token.SHR_ASSIGN:     ASSIGN,
+	token.PIPE:           PIPE,
+	token.OR:             OR,
+	token.AND:            AND,
+	token.EQ:             EQUALS,
+	token.NEQ:            EQUALS,
+	token.LT:             LESSGREATER,
+	token.GT:             LESSGREATER,
+	token.LTE:            LESSGREATER,
+	token.GTE:            LESSGREATER,
+	token.BIT_OR:         BITOR,
+	token.BIT_XOR:        BITXOR,
+	token.BIT_AND:        BITAND,
+	token.SHL:            SHIFT,
+	token.SHR:            SHIFT,
+	token.PLUS:           SUM,
+	token.MINUS:          SUM,
+	token.SLASH:          PRODUCT,
+	token.SPLAT:          PRODUCT,
+	token.MOD:            PRODUCT,
+	token.POW:            POWER,
+	token.LPAREN:         CALL,
+	token.LBRACKET:       INDEX,
+	token.DOT:            INDEX,
 }
 
 type Parser struct {
@@ -42,6 +79,22 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 
+	// comments holds the comments read since the last statement returned by
+	// Next, and stmtComments those returned with it.
+	comments     []*ast.Comment
+	stmtComments []*ast.Comment
+
+	// readFailed is set once the error reading the input has been reported.
+	readFailed bool
+
+	// panicking is set from a syntax error until the parser has skipped to
+	// the end of the statement.
+	panicking bool
+
+	// brackets holds the brackets opened and not yet closed up to and
+	// including the current token, innermost last.
+	brackets []token.TokenType
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -55,17 +108,24 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.NULL, p.parseNull)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.RAW_STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -76,8 +136,28 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NEQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LTE, p.parseInfixExpression)
+	p.registerInfix(token.GTE, p.parseInfixExpression)
+	p.registerInfix(token.MOD, p.parseInfixExpression)
+	p.registerInfix(token.POW, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	for _, t := range []token.TokenType{
+		token.ASSIGN, token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.SPLAT_ASSIGN,
+		token.SLASH_ASSIGN, token.MOD_ASSIGN, token.POW_ASSIGN, token.BIT_AND_ASSIGN,
+		token.BIT_OR_ASSIGN, token.BIT_XOR_ASSIGN, token.SHL_ASSIGN, token.SHR_ASSIGN,
+	} {
+		p.registerInfix(t, p.parseAssignExpression)
+	}
+	p.registerInfix(token.PIPE, p.parsePipeExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	//Read some shit
 	p.nextToken()
@@ -97,43 +177,135 @@ func (p *Parser) parseNull() ast.Expression {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.curToken.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE:
+		p.brackets = append(p.brackets, p.curToken.Type)
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		if len(p.brackets) > 0 {
+			p.brackets = p.brackets[:len(p.brackets)-1]
+		}
+	}
+
+	for p.peekTokenIs(token.COMMENT) {
+		p.comments = append(p.comments, &ast.Comment{Token: p.peekToken, Text: p.peekToken.Literal})
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() (*ast.Program, error) {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 
-	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
-		p.nextToken()
+	for stmt, ok := p.Next(); ok; stmt, ok = p.Next() {
+		program.Statements = append(program.Statements, stmt)
+		program.Comments = append(program.Comments, p.Comments()...)
 	}
 
 	return program, p.errors.ErrorOrNil()
 }
 
+// Next parses the next statement of the input. It returns false once the input
+// is used up, so a caller can run a script read from a stream one statement at
+// a time instead of holding all of it. Syntax errors, and an error reading the
+// input, are reported by Errors as they are found. The comments read with the
+// statement are returned by Comments until the next call.
+func (p *Parser) Next() (ast.Statement, bool) {
+	p.stmtComments = nil
+	if p.curTokenIs(token.EOF) {
+		if err := p.l.Err(); err != nil && !p.readFailed {
+			p.readFailed = true
+			p.addError(&Error{Pos: p.curToken.Pos, Msg: fmt.Sprintf("reading input: %s", err), Err: err})
+		}
+		return nil, false
+	}
+
+	stmt := p.parseStatement()
+	p.nextToken()
+	// The lookahead may have read comments inside the next statement already,
+	// leave those for it.
+	n := 0
+	for n < len(p.comments) && p.comments[n].Pos().Offset < p.curToken.Pos.Offset {
+		n++
+	}
+	p.stmtComments = p.comments[:n:n]
+	p.comments = p.comments[n:]
+	return stmt, true
+}
+
+// Comments returns the comments read with the statement last returned by Next:
+// those before and inside it, and any after it up to the next statement.
+func (p *Parser) Comments() []*ast.Comment {
+	return p.stmtComments
+}
+
+// parseStatement parses the statement starting at the current token. If it has
+// a syntax error, the rest of the statement is skipped, and a statement that
+// could not be parsed at all is returned as an *ast.BadStatement.
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.curToken
+	// The depth the statement starts at, before its first token.
+	depth := len(p.brackets)
+	switch start.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE:
+		depth--
+	}
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		stmt = p.parseLetStatement()
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			stmt = p.parseFunctionStatement()
+		} else {
+			stmt = p.parseExpressionStatement()
+		}
+	case token.EXPORT:
+		stmt = p.parseExportStatement()
+	case token.WHILE:
+		stmt = p.parseWhileStatement()
+	case token.FOR:
+		stmt = p.parseForStatement()
+	case token.BREAK:
+		stmt = &ast.BreakStatement{Token: p.curToken}
+		p.skipSemicolon()
+	case token.CONTINUE:
+		stmt = &ast.ContinueStatement{Token: p.curToken}
+		p.skipSemicolon()
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+
+	if p.panicking {
+		p.synchronize(depth)
+		p.panicking = false
+		if stmt == nil {
+			stmt = &ast.BadStatement{Token: start, To: p.curToken.End}
+		}
 	}
+
+	return stmt
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -141,7 +313,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
-	if f, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+	if f, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
 		f.Name = stmt.Name.Value
 	}
 
@@ -152,6 +324,197 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// parsePattern parses the target of a destructuring let: a name, or an array
+// or hash pattern.
+func (p *Parser) parsePattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.LBRACKET:
+		return p.parseArrayPattern(p.parsePattern)
+	case token.LBRACE:
+		return p.parseHashPattern(p.parsePattern)
+	default:
+		p.errorf(p.curToken.Pos, []token.TokenType{token.IDENT, token.LBRACE, token.LBRACKET},
+			"expected a name or pattern, got %s", p.curToken.Type)
+		return nil
+	}
+}
+
+// parseArrayPattern parses an array pattern, with parseElement parsing the
+// patterns of its elements.
+func (p *Parser) parseArrayPattern(parseElement func() ast.Expression) ast.Expression {
+	pat := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pat.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		el := parseElement()
+		if el == nil {
+			return nil
+		}
+		pat.Elements = append(pat.Elements, el)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	pat.Rbracket = p.curToken.Pos
+
+	return pat
+}
+
+// parseHashPattern parses a hash pattern, with parseValue parsing the patterns
+// of its values.
+func (p *Parser) parseHashPattern(parseValue func() ast.Expression) ast.Expression {
+	pat := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		if !p.curTokenIs(token.IDENT) && !p.curTokenIs(token.STRING) {
+			p.errorf(p.curToken.Pos, []token.TokenType{token.IDENT, token.STRING},
+				"expected a key, got %s", p.curToken.Type)
+			return nil
+		}
+		pair := ast.HashPatternPair{Key: &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}}
+
+		if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON) {
+			pair.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		} else {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+			pair.Value = parseValue()
+			if pair.Value == nil {
+				return nil
+			}
+		}
+		pat.Pairs = append(pat.Pairs, pair)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	pat.Rbrace = p.curToken.Pos
+
+	return pat
+}
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.skipSemicolon()
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForInStatement(stmt.Token)
+	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		if p.curTokenIs(token.LET) {
+			stmt.Init = p.parseLetStatement()
+		} else {
+			stmt.Init = p.parseExpressionStatement()
+		}
+		if stmt.Init == nil || !p.curTokenIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	if !p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		stmt.Update = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.skipSemicolon()
+
+	return stmt
+}
+
+// parseForInStatement parses the rest of a for-in loop, from its first
+// variable on.
+func (p *Parser) parseForInStatement(tok token.Token) ast.Statement {
+	stmt := &ast.ForInStatement{Token: tok}
+
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.skipSemicolon()
+
+	return stmt
+}
+
+// skipSemicolon moves past the optional semicolon ending a statement.
+func (p *Parser) skipSemicolon() {
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) curTokenIs(t token.TokenType) bool {
 	return p.curToken.Type == t
 }
@@ -170,12 +533,11 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Errorf("expected next token to be %s, got %s instead",
+	p.errorf(p.peekToken.Pos, []token.TokenType{t}, "expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = multierror.Append(p.errors, msg)
 }
 
-func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
+func (p *Parser) parseReturnStatement() ast.Statement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
 	p.nextToken()
@@ -202,7 +564,7 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
@@ -215,12 +577,17 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	start := p.curToken
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError((p.curToken.Type))
-		return nil
+		p.noPrefixParseFnError()
+		return &ast.BadExpression{Token: start, To: p.curToken.End}
 	}
 	leftExp := prefix()
+	if leftExp == nil {
+		leftExp = &ast.BadExpression{Token: start, To: p.curToken.End}
+	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -231,6 +598,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		p.nextToken()
 
 		leftExp = infix(leftExp)
+		if leftExp == nil {
+			leftExp = &ast.BadExpression{Token: start, To: p.curToken.End}
+		}
 	}
 
 	return leftExp
@@ -241,8 +611,28 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Errorf("could not parse %q as int: %v", p.curToken.Literal, err)
-		p.errors = multierror.Append(p.errors, msg)
+		if errors.Is(err, strconv.ErrRange) {
+			p.errorf(p.curToken.Pos, nil, "integer literal %s does not fit in 64 bits", p.curToken.Literal)
+		} else {
+			p.errorf(p.curToken.Pos, nil, "invalid integer literal %s", p.curToken.Literal)
+		}
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			p.errorf(p.curToken.Pos, nil, "float literal %s is out of range", p.curToken.Literal)
+		} else {
+			p.errorf(p.curToken.Pos, nil, "invalid float literal %s", p.curToken.Literal)
+		}
 		return nil
 	}
 
@@ -250,9 +640,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Errorf("no prefix parse function for %s found", t)
-	p.errors = multierror.Append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError() {
+	expected := make([]token.TokenType, 0, len(p.prefixParseFns))
+	for t := range p.prefixParseFns {
+		if t != token.ILLEGAL {
+			expected = append(expected, t)
+		}
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+
+	p.errorf(p.curToken.Pos, expected, "expected an expression, got %s", p.curToken.Type)
+}
+
+func (p *Parser) parseIllegal() ast.Expression {
+	p.errorf(p.curToken.Pos, nil, "%s", p.curToken.Literal)
+	return nil
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -290,12 +692,37 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if p.curTokenIs(token.POW) {
+		// ** is right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2).
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   left,
+		Operator: strings.TrimSuffix(p.curToken.Literal, "="),
+	}
+
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression, *ast.MemberExpression:
+	default:
+		p.errorf(left.Pos(), nil, "cannot assign to %s", left)
+		return nil
+	}
+
+	p.nextToken()
+	// Assignment is right associative: a = b = c is a = (b = c).
+	exp.Value = p.parseExpression(ASSIGN - 1)
+
+	return exp
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -315,12 +742,42 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 func (p *Parser) parseIfExpression() ast.Expression {
 	exp := &ast.IfExpression{Token: p.curToken}
 
+	for {
+		branch := p.parseIfBranch()
+		if branch == nil {
+			return nil
+		}
+		exp.Branches = append(exp.Branches, branch)
+
+		if !p.peekTokenIs(token.ELSE) {
+			return exp
+		}
+		p.nextToken()
+
+		if !p.peekTokenIs(token.IF) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	exp.Alternative = p.parseBlockStatement()
+
+	return exp
+}
+
+// parseIfBranch parses the condition and block following an if token.
+func (p *Parser) parseIfBranch() *ast.IfBranch {
+	branch := &ast.IfBranch{Token: p.curToken}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
 	p.nextToken()
-	exp.Condition = p.parseExpression(LOWEST)
+	branch.Condition = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
@@ -330,20 +787,113 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		return nil
 	}
 
-	exp.Consequence = p.parseBlockStatement()
+	branch.Consequence = p.parseBlockStatement()
+	return branch
+}
 
-	if p.peekTokenIs(token.ELSE) {
+// parseMatchExpression parses match (subject) { pattern [if guard] => body, ...}.
+// A body starting with { is always a block, so a body that is a hash literal
+// has to be put in parentheses: 1 => ({"kind": "a"}).
+func (p *Parser) parseMatchExpression() ast.Expression {
+	exp := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	exp.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		exp.Arms = append(exp.Arms, arm)
 
-		if !p.expectPeek(token.LBRACE) {
+		// The comma is optional after a block.
+		if _, block := arm.Body.(*ast.BlockStatement); block && !p.peekTokenIs(token.COMMA) {
+			continue
+		}
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
-		exp.Alternative = p.parseBlockStatement()
 	}
 
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	exp.Rbrace = p.curToken.Pos
+
 	return exp
 }
 
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Pattern: p.parseMatchPattern()}
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	p.nextToken()
+	if p.curTokenIs(token.LBRACE) {
+		arm.Body = p.parseBlockStatement()
+	} else {
+		arm.Body = p.parseExpression(LOWEST)
+	}
+
+	return arm
+}
+
+// parseMatchPattern parses the pattern of a match arm: a literal, a name to
+// bind, _, or an array or hash pattern of those.
+func (p *Parser) parseMatchPattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return p.parseIdentifier()
+	case token.LBRACKET:
+		return p.parseArrayPattern(p.parseMatchPattern)
+	case token.LBRACE:
+		return p.parseHashPattern(p.parseMatchPattern)
+	case token.INT, token.FLOAT, token.STRING, token.RAW_STRING, token.TRUE, token.FALSE, token.NULL:
+		return p.prefixParseFns[p.curToken.Type]()
+	case token.MINUS:
+		if p.peekTokenIs(token.INT) || p.peekTokenIs(token.FLOAT) {
+			exp := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+			p.nextToken()
+			exp.Right = p.prefixParseFns[p.curToken.Type]()
+			if exp.Right == nil {
+				return nil
+			}
+			return exp
+		}
+	}
+
+	p.errorf(p.curToken.Pos, nil, "expected a pattern, got %s", p.curToken.Type)
+	return nil
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -351,77 +901,225 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
+		block.Statements = append(block.Statements, p.parseStatement())
 		p.nextToken()
 	}
+	if p.curTokenIs(token.EOF) {
+		p.errorf(p.curToken.Pos, []token.TokenType{token.RBRACE}, "expected %s to close block, got %s", token.RBRACE, token.EOF)
+	}
+	block.Rbrace = p.curToken.Pos
+
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
-
-	if !p.expectPeek(token.LPAREN) {
+	if !p.parseFunction(lit) {
 		return nil
 	}
+	return lit
+}
 
-	lit.Parameters = p.parseFunctionParameters()
+func (p *Parser) parseFunctionStatement() ast.Statement {
+	stmt := &ast.FunctionStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.LBRACE) {
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	stmt.Function = &ast.FunctionLiteral{Token: stmt.Token, Name: stmt.Name.Value}
+	if !p.parseFunction(stmt.Function) {
 		return nil
 	}
-	lit.Body = p.parseBlockStatement()
+	p.skipSemicolon()
 
-	return lit
+	return stmt
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
 
-	if p.peekTokenIs(token.RPAREN) {
-		p.nextToken()
-		return identifiers
+	p.nextToken()
+	switch {
+	case p.curTokenIs(token.LET):
+		stmt.Statement = p.parseLetStatement()
+	case p.curTokenIs(token.FUNCTION) && p.peekTokenIs(token.IDENT):
+		stmt.Statement = p.parseFunctionStatement()
+	default:
+		p.errorf(p.curToken.Pos, []token.TokenType{token.LET, token.FUNCTION},
+			"expected a let or fn declaration after export, got %s", p.curToken.Type)
+	}
+	if stmt.Statement == nil {
+		return nil
 	}
 
-	p.nextToken()
+	return stmt
+}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+func (p *Parser) parseImportExpression() ast.Expression {
+	imp := &ast.ImportExpression{Token: p.curToken}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
-		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+	if !p.expectPeek(token.LPAREN) {
+		return nil
 	}
-
+	p.nextToken()
+	if !p.curTokenIs(token.STRING) && !p.curTokenIs(token.RAW_STRING) {
+		p.errorf(p.curToken.Pos, []token.TokenType{token.STRING},
+			"expected a module path, got %s", p.curToken.Type)
+		return nil
+	}
+	imp.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
+	imp.Rparen = p.curToken.Pos
+
+	return imp
+}
+
+// parseFunction parses the parameters and body of lit.
+func (p *Parser) parseFunction(lit *ast.FunctionLiteral) bool {
+	if !p.expectPeek(token.LPAREN) {
+		return false
+	}
+
+	if !p.parseFunctionParameters(lit) {
+		return false
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return false
+	}
+	lit.Body = p.parseBlockStatement()
+
+	return true
+}
+
+// parseFunctionParameters parses the parameters of lit, up to and including
+// the closing parenthesis: names, optionally followed by a default value, and
+// a final ...rest parameter.
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RPAREN) {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		var def ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			def = p.parseExpression(ASSIGN)
+		} else if len(lit.Defaults) > 0 {
+			p.errorf(ident.Pos(), nil, "parameter %s needs a default value, it follows a parameter with one", ident.Value)
+			return false
+		}
+
+		lit.Parameters = append(lit.Parameters, ident)
+		if def != nil || len(lit.Defaults) > 0 {
+			for len(lit.Defaults) < len(lit.Parameters)-1 {
+				lit.Defaults = append(lit.Defaults, nil)
+			}
+			lit.Defaults = append(lit.Defaults, def)
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
 
-	return identifiers
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseCallExpression(f ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: f}
-	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Arguments = p.parseList(token.RPAREN, p.parseArgument)
+	exp.Rparen = p.curToken.Pos
 	return exp
 }
 
+// parsePipeExpression rewrites x |> f(a) into the call f(x, a), and x |> f
+// into f(x). Only the function and the call right after it belong to the
+// pipe, so x |> f(a) + 1 is f(x, a) + 1.
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+	p.nextToken()
+
+	fn := p.parseExpression(CALL)
+	if !p.peekTokenIs(token.LPAREN) {
+		return &ast.CallExpression{Token: tok, Function: fn, Arguments: []ast.Expression{left}}
+	}
+
+	p.nextToken()
+	call := p.parseCallExpression(fn).(*ast.CallExpression)
+	call.Arguments = append([]ast.Expression{left}, call.Arguments...)
+	return call
+}
+
+// parseArgument parses an argument of a call, which may spread an array.
+func (p *Parser) parseArgument() ast.Expression {
+	if p.curTokenIs(token.ELLIPSIS) {
+		spread := &ast.SpreadExpression{Token: p.curToken}
+		p.nextToken()
+		spread.Value = p.parseExpression(LOWEST)
+		return spread
+	}
+	return p.parseExpression(LOWEST)
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+	str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+
+	for !p.curTokenIs(token.STRING_TAIL) {
+		p.nextToken()
+		if p.curTokenIs(token.STRING_MIDDLE) || p.curTokenIs(token.STRING_TAIL) {
+			p.errorf(p.curToken.Pos, nil, "empty interpolation in string")
+			return nil
+		}
+		str.Parts = append(str.Parts, p.parseExpression(LOWEST))
+
+		switch {
+		case p.peekTokenIs(token.STRING_MIDDLE):
+			p.nextToken()
+		case !p.expectPeek(token.STRING_TAIL):
+			return nil
+		}
+		str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	return str
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken.Pos
 
 	return array
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	return p.parseList(end, func() ast.Expression { return p.parseExpression(LOWEST) })
+}
+
+// parseList parses a comma separated list of items up to end.
+func (p *Parser) parseList(end token.TokenType, parseItem func() ast.Expression) []ast.Expression {
 	list := []ast.Expression{}
 
 	if p.peekTokenIs(end) {
@@ -430,12 +1128,12 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	}
 
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	list = append(list, parseItem())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		list = append(list, p.parseExpression(LOWEST))
+		list = append(list, parseItem())
 	}
 
 	if !p.expectPeek(end) {
@@ -446,14 +1144,42 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.curTokenIs(token.COLON) {
+		index = p.parseExpression(LOWEST)
+		if !p.peekTokenIs(token.COLON) {
+			if !p.expectPeek(token.RBRACKET) {
+				return nil
+			}
+			return &ast.IndexExpression{Token: tok, Left: left, Index: index, Rbracket: p.curToken.Pos}
+		}
+		p.nextToken()
+	}
+
+	exp := &ast.SliceExpression{Token: tok, Left: left, Low: index}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken.Pos
+
+	return exp
+}
+
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.curToken, Left: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	return exp
 }
@@ -483,5 +1209,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken.Pos
+
 	return hash
 }
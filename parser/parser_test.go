@@ -1,12 +1,18 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"iscript/ast"
 	"iscript/lexer"
 	"iscript/token"
+	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/kylelemons/godebug/pretty"
 )
 
@@ -159,8 +165,9 @@ func TestParserStruct(t *testing.T) {
 		if (err != nil) != tt.wantErr {
 			t.Errorf("%s: got err %v when wantErr is %v", tt.name, err, tt.wantErr)
 		}
-		if diff := pretty.Compare(got, tt.expected); diff != "" {
-			t.Errorf("%s: NextToken diff: (-got +want)\n%s", tt.name, diff)
+		// Positions are covered by TestNodePositions.
+		if diff := cmp.Diff(tt.expected, got, cmpopts.IgnoreTypes(token.Position{})); diff != "" {
+			t.Errorf("%s: ParseProgram diff: (-want +got)\n%s", tt.name, diff)
 		}
 	}
 }
@@ -235,6 +242,53 @@ func TestOpPrecedenceParsing(t *testing.T) {
 		{"a + add(b * c) + d", "((a + add((b * c))) + d)"},
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c && d", "((a && b) || (c && d))"},
+		{"a || b || c", "((a || b) || c)"},
+		{"a == b && c < d", "((a == b) && (c < d))"},
+		{"!a && -b > c", "((!a) && ((-b) > c))"},
+		{"a <= b == c >= d", "((a <= b) == (c >= d))"},
+		{"a % b * c", "((a % b) * c)"},
+		{"a ** b ** c", "(a ** (b ** c))"},
+		{"-a ** b", "(-(a ** b))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"a & b == c", "((a & b) == c)"},
+		{"a << b + c", "(a << (b + c))"},
+		{"a & b << c", "(a & (b << c))"},
+		{"~a & b", "((~a) & b)"},
+		{"x = 5", "(x = 5)"},
+		{"x = y + 1", "(x = (y + 1))"},
+		{"a = b = c || d", "(a = (b = (c || d)))"},
+		{"x += 1", "(x += 1)"},
+		{"x **= y -= 2 * 3", "(x **= (y -= (2 * 3)))"},
+		{"a[0] = 1", "((a[0]) = 1)"},
+		{"h[k] <<= 1 + 1", "((h[k]) <<= (1 + 1))"},
+		{"x |> f", "f(x)"},
+		{"x |> f(a) |> g", "g(f(x, a))"},
+		{"a + b |> f(c)", "f((a + b), c)"},
+		{"a || b |> f == g", "(a || (f(b) == g))"},
+		{"xs |> len > 0", "(len(xs) > 0)"},
+		{"a | b |> f", "f((a | b))"},
+		{"x |> (f(a))", "f(a)(x)"},
+		{"x |> (f)(a)", "f(x, a)"},
+		{"y = x |> f", "(y = f(x))"},
+		{"x |> h[k] |> f(a)(b)", "f((h[k])(x), a)(b)"},
+		{"x |> f + 1", "(f(x) + 1)"},
+		{"x |> f(a)[0]", "(f(x, a)[0])"},
+		{"x |> f(a) * 2 |> g", "g((f(x, a) * 2))"},
+		{"x |> -f", "(-f)(x)"},
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:-1]", "(a[:(-1)])"},
+		{"s[2:]", "(s[2:])"},
+		{"a[:]", "(a[:])"},
+		{"a[i + 1:][0]", "((a[(i + 1):])[0])"},
+		{"a.b.c", "((a.b).c)"},
+		{"a.b(c)", "(a.b)(c)"},
+		{"-a.b * c", "((-(a.b)) * c)"},
+		{"a.b[0].c", "(((a.b)[0]).c)"},
+		{"h.x += 1", "((h.x) += 1)"},
+		{"xs |> a.f(b)", "(a.f)(xs, b)"},
 	}
 
 	for _, tt := range tests {
@@ -277,17 +331,22 @@ func TestIfExpression(t *testing.T) {
 		t.Fatalf("exp is not ast.IfExpression. got=%T", stmt.Expression)
 	}
 
-	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+	if len(exp.Branches) != 1 {
+		t.Fatalf("exp.Branches does not contain %d branches. got=%d", 1, len(exp.Branches))
+	}
+	branch := exp.Branches[0]
+
+	if !testInfixExpression(t, branch.Condition, "x", "<", "y") {
 		return
 	}
 
-	if len(exp.Consequence.Statements) != 1 {
-		t.Errorf("consequence is not %d statements. got=%d", 1, len(exp.Consequence.Statements))
+	if len(branch.Consequence.Statements) != 1 {
+		t.Errorf("consequence is not %d statements. got=%d", 1, len(branch.Consequence.Statements))
 	}
 
-	c, ok := exp.Consequence.Statements[0].(*ast.ExpressionStatement)
+	c, ok := branch.Consequence.Statements[0].(*ast.ExpressionStatement)
 	if !ok {
-		t.Fatalf("Statement0 is not ast.ExpressionStatement. got=%T", exp.Consequence.Statements[0])
+		t.Fatalf("Statement0 is not ast.ExpressionStatement. got=%T", branch.Consequence.Statements[0])
 	}
 
 	if !testIdentifier(t, c.Expression, "x") {
@@ -299,6 +358,42 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		branches int
+		hasAlt   bool
+		want     string
+	}{
+		{"if (a) { 1 } else { 2 }", 1, true, "ifa 1else 2"},
+		{"if (a) { 1 } else if (b) { 2 }", 2, false, "ifa 1else ifb 2"},
+		{"if (a) { 1 } else if (b) { 2 } else if (c) { 3 } else { 4 }", 3, true, "ifa 1else ifb 2else ifc 3else 4"},
+	}
+
+	for _, tt := range tests {
+		program, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.IfExpression)
+		if !ok {
+			t.Errorf("%q: exp is not ast.IfExpression. got=%T", tt.input, stmt.Expression)
+			continue
+		}
+		if len(exp.Branches) != tt.branches {
+			t.Errorf("%q: wrong number of branches. want=%d, got=%d", tt.input, tt.branches, len(exp.Branches))
+		}
+		if (exp.Alternative != nil) != tt.hasAlt {
+			t.Errorf("%q: wrong alternative. want=%t, got=%+v", tt.input, tt.hasAlt, exp.Alternative)
+		}
+		if got := exp.String(); got != tt.want {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
 func testIntLiteral(t *testing.T, l ast.Expression, v int64) bool {
 	i, ok := l.(*ast.IntegerLiteral)
 	if !ok {
@@ -414,6 +509,155 @@ func TestFuncLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFuncParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(a, b = 10) { a }", "fn(a, b = 10) a"},
+		{"fn(a, b = a * 2, ...rest) { a }", "fn(a, b = (a * 2), ...rest) a"},
+		{"fn(...rest) { rest }", "fn(...rest) rest"},
+		{"fn(a,) { a }", "fn(a) a"},
+		{"f(...xs, 1, ...[2])", "f(...xs, 1, ...[2])"},
+	}
+
+	for _, tt := range tests {
+		prog, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		if got := prog.String(); got != tt.want {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"fn add(a, b = 1) { a + b }", []string{"fn add(a, b = 1) (a + b)"}},
+		{"fn f() {}; f()", []string{"fn f() ", "f()"}},
+		{"fn(x) { x }(1)", []string{"fn(x) x(1)"}},
+	}
+
+	for _, tt := range tests {
+		prog, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		var got []string
+		for _, s := range prog.Statements {
+			got = append(got, s.String())
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q: wrong statements (-want +got):\n%s", tt.input, diff)
+		}
+	}
+
+	prog, err := New(lexer.New("fn add(a, b) { a + b }")).ParseProgram()
+	if err != nil {
+		t.Fatalf("failed to parse program: err: %v", err)
+	}
+	stmt, ok := prog.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.FunctionStatement. got=%T", prog.Statements[0])
+	}
+	if stmt.Function.Name != "add" {
+		t.Errorf("function literal has wrong name. want=%q, got=%q", "add", stmt.Function.Name)
+	}
+}
+
+func TestBadFuncParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(a = 1, b) {}", "1:11: parameter b needs a default value, it follows a parameter with one"},
+		{"fn(...r, a) {}", "1:8: expected next token to be ), got , instead"},
+		{"fn(1) {}", "1:4: expected next token to be IDENT, got INT instead"},
+		{"fn(a b) {}", "1:6: expected next token to be ), got IDENT instead"},
+		{"[...a]", "1:2: expected an expression, got ..."},
+	}
+
+	for _, tt := range tests {
+		_, err := New(lexer.New(tt.input)).ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse error but none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`let u = import("./util.is"); u.f()`, []string{"let u = import(./util.is);", "(u.f)()"}},
+		{"import(`lib.is`).x", []string{"(import(lib.is).x)"}},
+		{"export let x = 1; export fn f(a) { a }", []string{"export let x = 1;", "export fn f(a) a"}},
+		{"export let [a, {b}] = v;", []string{"export let [a, {b}] = v;"}},
+	}
+
+	for _, tt := range tests {
+		prog, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		var got []string
+		for _, s := range prog.Statements {
+			got = append(got, s.String())
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q: wrong statements (-want +got):\n%s", tt.input, diff)
+		}
+	}
+
+	prog, err := New(lexer.New("export let [a, {b, c: [d, ...e]}] = v;")).ParseProgram()
+	if err != nil {
+		t.Fatalf("failed to parse program: err: %v", err)
+	}
+	stmt, ok := prog.Statements[0].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExportStatement. got=%T", prog.Statements[0])
+	}
+	if diff := cmp.Diff([]string{"a", "b", "d", "e"}, stmt.Names()); diff != "" {
+		t.Errorf("wrong exported names (-want +got):\n%s", diff)
+	}
+}
+
+func TestBadImportExport(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"import(x)", "1:8: expected a module path, got IDENT"},
+		{`import "a.is"`, "1:8: expected next token to be (, got STRING instead"},
+		{`import("a.is"`, "1:14: expected next token to be ), got EOF instead"},
+		{"export 1", "1:8: expected a let or fn declaration after export, got INT"},
+		{"export fn() {}", "1:8: expected a let or fn declaration after export, got FUNCTION"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(lexer.New(tt.input)).ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse error but none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
 func TestCallFunc(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5)"
 
@@ -600,3 +844,724 @@ func TestFuncLiteralWithName(t *testing.T) {
 		t.Fatalf("function literal name wrong. want 'myFunc', got=%q", fn.Name)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let x = 5;
+let add = fn(a, b) {
+	a + b;
+};
+add(x, [1, 2])[0];`
+
+	l := lexer.New(input)
+	p := New(l)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("failed to parse program: err: %v", err)
+	}
+
+	if len(prog.Statements) != 3 {
+		t.Fatalf("prog.Statements does not contain %d statements. got=%d", 3, len(prog.Statements))
+	}
+
+	let := prog.Statements[0].(*ast.LetStatement)
+	fnLet := prog.Statements[1].(*ast.LetStatement)
+	fnLit := fnLet.Value.(*ast.FunctionLiteral)
+	body := fnLit.Body.Statements[0].(*ast.ExpressionStatement)
+	idx := prog.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	call := idx.Left.(*ast.CallExpression)
+
+	tests := []struct {
+		name     string
+		node     ast.Node
+		pos, end token.Position
+	}{
+		{"let", let, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 9, Line: 1, Column: 10}},
+		{"let name", let.Name, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{"fn literal", fnLit, token.Position{Offset: 21, Line: 2, Column: 11}, token.Position{Offset: 41, Line: 4, Column: 2}},
+		{"infix", body.Expression, token.Position{Offset: 33, Line: 3, Column: 2}, token.Position{Offset: 38, Line: 3, Column: 7}},
+		{"call", call, token.Position{Offset: 43, Line: 5, Column: 1}, token.Position{Offset: 57, Line: 5, Column: 15}},
+		{"index", idx, token.Position{Offset: 43, Line: 5, Column: 1}, token.Position{Offset: 60, Line: 5, Column: 18}},
+		{"program", prog, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 60, Line: 5, Column: 18}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.node.Pos(); got != tt.pos {
+			t.Errorf("%s: wrong Pos. want=%#v, got=%#v", tt.name, tt.pos, got)
+		}
+		if got := tt.node.End(); got != tt.end {
+			t.Errorf("%s: wrong End. want=%#v, got=%#v", tt.name, tt.end, got)
+		}
+	}
+}
+
+func TestPipeCallEnd(t *testing.T) {
+	prog, err := New(lexer.New("x |> f.g")).ParseProgram()
+	if err != nil {
+		t.Fatalf("failed to parse program: err: %v", err)
+	}
+
+	call := prog.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if got := call.End().Offset; got != 8 {
+		t.Errorf("wrong End of a call without parentheses. want=8, got=%d", got)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := `let x = 5;
+let = 10;`
+
+	l := lexer.New(input)
+	p := New(l)
+	_, err := p.ParseProgram()
+	if err == nil {
+		t.Fatalf("expected parse error but none")
+	}
+
+	want := "2:5: expected next token to be IDENT, got = instead"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not contain %q. got=%q", want, err)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{
+			"let = 5;\nlet y = 10;\nlet z = (1 + ;\nlet w = 3;\nfn(x { x };\nlet ok = 1;",
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"3:14: expected an expression, got ;",
+				"5:6: expected next token to be ), got { instead",
+			},
+		},
+		{
+			"let a = [1, 2 3]\nlet b = 2;",
+			[]string{"1:15: expected next token to be ], got INT instead"},
+		},
+		{
+			"let a = s[1:2:3]\nlet b = s[:2;",
+			[]string{
+				"1:14: expected next token to be ], got : instead",
+				"2:13: expected next token to be ], got ; instead",
+			},
+		},
+		{
+			"let f = fn() {\n  let = 1;\n  let y = );\n  y\n};\nf(",
+			[]string{
+				"2:7: expected next token to be IDENT, got = instead",
+				"3:11: expected an expression, got )",
+				"6:3: expected an expression, got EOF",
+			},
+		},
+		{
+			"let x = * 2 @ 3;\nlet y = 1 # 2;",
+			[]string{
+				"1:9: expected an expression, got *",
+				"1:13: illegal character '@'",
+				"2:11: illegal character '#'",
+			},
+		},
+		{
+			"if (x) { x",
+			[]string{"1:11: expected } to close block, got EOF"},
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		_, err := p.ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse errors but none", tt.input)
+			continue
+		}
+
+		var got []string
+		for _, e := range p.Errors() {
+			got = append(got, e.Error())
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q: wrong errors (-want +got):\n%s", tt.input, diff)
+		}
+	}
+}
+
+func TestErrorRecoveryOpenBrackets(t *testing.T) {
+	tests := []string{
+		`let h = {"a": 1, "b" 2}; 5`,
+		`let {a: 1} = {"a":1}`,
+		"match (1) { [...] => 1 }",
+		"let a = f(g(1 2)); let b = 2;",
+		"let x = [1 2] }\nlet y = 1;",
+		"fn() { let = [1, {2: 3}]; 4 }",
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt))
+		if _, err := p.ParseProgram(); err == nil {
+			t.Errorf("%q: expected parse error but none", tt)
+			continue
+		}
+		if errs := p.Errors(); len(errs) != 1 {
+			t.Errorf("%q: wrong number of errors. want=1, got=%d (%v)", tt, len(errs), errs)
+		}
+	}
+}
+
+func TestErrorExpected(t *testing.T) {
+	p := New(lexer.New("let x 5;\nlet y = ;"))
+	_, err := p.ParseProgram()
+
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("error is not a *Error. got=%T (%v)", err, err)
+	}
+
+	errs := p.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("wrong number of errors. want=2, got=%d (%v)", len(errs), err)
+	}
+
+	if errs[0].Pos.Line != 1 || errs[0].Pos.Column != 7 {
+		t.Errorf("wrong position. want=1:7, got=%s", errs[0].Pos)
+	}
+	if diff := cmp.Diff([]token.TokenType{token.ASSIGN}, errs[0].Expected); diff != "" {
+		t.Errorf("wrong expected tokens (-want +got):\n%s", diff)
+	}
+
+	for _, tt := range []token.TokenType{token.IDENT, token.INT, token.LPAREN, token.FUNCTION} {
+		found := false
+		for _, e := range errs[1].Expected {
+			found = found || e == tt
+		}
+		if !found {
+			t.Errorf("%s missing from expected tokens %v", tt, errs[1].Expected)
+		}
+	}
+}
+
+func TestBadNodes(t *testing.T) {
+	input := "let = 1;\nlet x = 1 + ;\nx;"
+
+	p := New(lexer.New(input))
+	prog, _ := p.ParseProgram()
+
+	if len(prog.Statements) != 3 {
+		t.Fatalf("prog.Statements does not contain %d statements. got=%d", 3, len(prog.Statements))
+	}
+
+	bad, ok := prog.Statements[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("statement 0 is not *ast.BadStatement. got=%T", prog.Statements[0])
+	}
+	if bad.Pos().Offset != 0 || bad.End().Offset != 8 {
+		t.Errorf("wrong span. want=0-8, got=%d-%d", bad.Pos().Offset, bad.End().Offset)
+	}
+
+	let, ok := prog.Statements[1].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("statement 1 is not *ast.LetStatement. got=%T", prog.Statements[1])
+	}
+	infix, ok := let.Value.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("let value is not *ast.InfixExpression. got=%T", let.Value)
+	}
+	if _, ok := infix.Right.(*ast.BadExpression); !ok {
+		t.Errorf("infix right is not *ast.BadExpression. got=%T", infix.Right)
+	}
+
+	if _, ok := prog.Statements[2].(*ast.ExpressionStatement); !ok {
+		t.Errorf("statement 2 is not *ast.ExpressionStatement. got=%T", prog.Statements[2])
+	}
+}
+
+func TestBadAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 = 2", "1:1: cannot assign to 1"},
+		{"a + b = 3", "1:1: cannot assign to (a + b)"},
+		{"f() = 3", "1:1: cannot assign to f()"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(lexer.New(tt.input)).ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse error but none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, b] = x;", "let [a, b] = x;"},
+		{"let [a, ...rest] = x", "let [a, ...rest] = x;"},
+		{"let [...all] = x", "let [...all] = x;"},
+		{"let [] = x", "let [] = x;"},
+		{"let [a, b,] = x", "let [a, b] = x;"},
+		{"let {name, age: years} = p;", "let {name, age: years} = p;"},
+		{`let {"first name": f} = p;`, "let {first name: f} = p;"},
+		{"let [x, {y, z: [z, ...zs]}] = v;", "let [x, {y, z: [z, ...zs]}] = v;"},
+	}
+
+	for _, tt := range tests {
+		prog, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		if got := prog.String(); got != tt.want {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestBadDestructuringLet(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, 1] = x", "1:9: expected a name or pattern, got INT"},
+		{"let [...r, a] = x", "1:10: expected next token to be ], got , instead"},
+		{"let [a b] = x", "1:8: expected next token to be ,, got IDENT instead"},
+		{"let {1: a} = x", "1:6: expected a key, got INT"},
+		{`let {"a"} = x`, "1:9: expected next token to be :, got } instead"},
+		{"let [a] x", "1:9: expected next token to be =, got IDENT instead"},
+		{"let [a].b = x", "1:8: expected next token to be =, got . instead"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(lexer.New(tt.input)).ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse error but none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"while (x < 10) { x = x + 1; }", "while ((x < 10)) (x = (x + 1))"},
+		{"while (true) { break; continue }", "while (true) break;continue;"},
+		{"for (let i = 0; i < n; i += 1) { f(i) }", "for (let i = 0; (i < n); (i += 1)) f(i)"},
+		{"for (i = 0; i < n; i = i + 1) {}", "for ((i = 0); (i < n); (i = (i + 1))) "},
+		{"for (;;) { break; }", "for (; ; ) break;"},
+		{"for (; x;) { x = false };", "for (; x; ) (x = false)"},
+		{"for (x in xs) { f(x) }", "for (x in xs) f(x)"},
+		{"for (k, v in h) { f(k, v) }", "for (k, v in h) f(k, v)"},
+		{"for (x in range(1, 3)) {}", "for (x in range(1, 3)) "},
+	}
+
+	for _, tt := range tests {
+		prog, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		if len(prog.Statements) != 1 {
+			t.Errorf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(prog.Statements))
+			continue
+		}
+		if got := prog.Statements[0].String(); got != tt.want {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestBadLoopStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"while x { }", "1:7: expected next token to be (, got IDENT instead"},
+		{"for (let i = 0 i < 3; i += 1) {}", "1:16: expected next token to be ;, got IDENT instead"},
+		{"for (;; i += 1 {}", "1:16: expected next token to be ), got { instead"},
+		{"while (true) { 1", "1:17: expected } to close block, got EOF"},
+		{"for (k, in h) {}", "1:9: expected next token to be IDENT, got IN instead"},
+		{"for (k, v of h) {}", "1:11: expected next token to be IN, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(lexer.New(tt.input)).ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse error but none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// add things
+let add = fn(a, b) { a + b; }; /* inline */
+add(1, 2); // done`
+
+	l := lexer.New(input)
+	p := New(l)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("failed to parse program: err: %v", err)
+	}
+
+	if len(prog.Statements) != 2 {
+		t.Fatalf("prog.Statements does not contain %d statements. got=%d", 2, len(prog.Statements))
+	}
+
+	want := []string{"// add things", "/* inline */", "// done"}
+	if len(prog.Comments) != len(want) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(want), len(prog.Comments))
+	}
+	for i, c := range prog.Comments {
+		if c.Text != want[i] {
+			t.Errorf("comment %d wrong. want=%q, got=%q", i, want[i], c.Text)
+		}
+	}
+
+	if got := prog.Comments[1].Pos(); got.Line != 2 || got.Column != 32 {
+		t.Errorf("wrong position for comment 1. got=%s", got)
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x = 1; /* never closed", "1:12: unterminated block comment"},
+		{"let x = \"never closed;", "1:9: unterminated string"},
+		{"let x = \"\\q\";", "1:10: invalid escape sequence: \\q"},
+		{"let x = \"ok ${1} \\q\";", "1:18: invalid escape sequence: \\q"},
+		{"let x = 1 @ 2;", "1:11: illegal character '@'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		_, err := p.ParseProgram()
+		if err == nil {
+			t.Errorf("%s: expected parse error but none", tt.input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf("failed to parse program: err: %v", err)
+		}
+
+		stmt := prog.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+
+		if lit.Value != tt.want {
+			t.Errorf("lit.Value not %g. got=%g", tt.want, lit.Value)
+		}
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"0xFF", 255},
+		{"0Xff", 255},
+		{"0o755", 493},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0xFFFF_FFFF", 4294967295},
+		{"9223372036854775807", 9223372036854775807},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: failed to parse program: err: %v", tt.input, err)
+		}
+
+		stmt := prog.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("%s: exp is not ast.IntegerLiteral. got=%T", tt.input, stmt.Expression)
+		}
+
+		if lit.Value != tt.want {
+			t.Errorf("%s: lit.Value not %d. got=%d", tt.input, tt.want, lit.Value)
+		}
+	}
+}
+
+func TestBadNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x = 9223372036854775808;", "1:9: integer literal 9223372036854775808 does not fit in 64 bits"},
+		{"let x = 0x1_0000_0000_0000_0000;", "1:9: integer literal 0x1_0000_0000_0000_0000 does not fit in 64 bits"},
+		{"let x = 0b102;", "1:9: invalid integer literal 0b102"},
+		{"let x = 0x;", "1:9: invalid integer literal 0x"},
+		{"let x = 1__0;", "1:9: invalid integer literal 1__0"},
+		{"let x = 10_;", "1:9: invalid integer literal 10_"},
+		{"let x = 1e999;", "1:9: float literal 1e999 is out of range"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		_, err := p.ParseProgram()
+		if err == nil {
+			t.Errorf("%s: expected parse error but none", tt.input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestInterpolatedStringParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"hello ${name}!"`, "hello ${name}!"},
+		{`"${a + b * 2}"`, "${(a + (b * 2))}"},
+		{`"you have ${len(items)} items, ${x} ${"nested ${y}"}"`, "you have ${len(items)} items, ${x} ${nested ${y}}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog, err := p.ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: failed to parse program: err: %v", tt.input, err)
+		}
+
+		stmt := prog.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("%s: exp is not ast.InterpolatedString. got=%T", tt.input, stmt.Expression)
+		}
+
+		if len(str.Parts)%2 != 1 {
+			t.Errorf("%s: want an odd number of parts. got=%d", tt.input, len(str.Parts))
+		}
+
+		if got := str.String(); got != tt.want {
+			t.Errorf("%s: wrong String(). want=%q, got=%q", tt.input, tt.want, got)
+		}
+
+		if got := str.End().Offset; got != len(tt.input) {
+			t.Errorf("%s: wrong End offset. want=%d, got=%d", tt.input, len(tt.input), got)
+		}
+	}
+}
+
+func TestBadInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"empty ${}"`, "1:10: empty interpolation in string"},
+		{`"open ${x"`, "unterminated string"},
+		{`"two ${x y}"`, "1:10: expected next token to be STRING_TAIL, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		_, err := p.ParseProgram()
+		if err == nil {
+			t.Errorf("%s: expected parse error but none", tt.input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestParseFromReader(t *testing.T) {
+	input := "let add = fn(a, b) { a + b };\n" + strings.Repeat("add(1, 2);\n", 1000)
+
+	l := lexer.NewReader(iotest.OneByteReader(strings.NewReader(input)))
+	p := New(l)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("failed to parse program: err: %v", err)
+	}
+
+	if len(prog.Statements) != 1001 {
+		t.Errorf("prog.Statements does not contain %d statements. got=%d", 1001, len(prog.Statements))
+	}
+
+	last := prog.Statements[1000]
+	if got := last.Pos(); got.Line != 1001 || got.Column != 1 {
+		t.Errorf("wrong position for last statement. got=%s", got)
+	}
+}
+
+func TestParseReaderError(t *testing.T) {
+	errPipe := errors.New("broken pipe")
+	r := io.MultiReader(strings.NewReader("let x = 1;"), iotest.ErrReader(errPipe))
+
+	p := New(lexer.NewReader(r))
+	_, err := p.ParseProgram()
+	if err == nil {
+		t.Fatalf("expected error but none")
+	}
+
+	want := "reading input: broken pipe"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not contain %q. got=%q", want, err)
+	}
+	if !errors.Is(err, errPipe) {
+		t.Errorf("error does not wrap the reader error. got=%q", err)
+	}
+
+	errs := p.Errors()
+	if len(errs) != 1 || errs[0].Err != errPipe {
+		t.Errorf("reader error is not in Errors. got=%v", errs)
+	}
+}
+
+func TestNext(t *testing.T) {
+	input := "let x = 1;\nlet = 2;\nx + 1"
+
+	p := New(lexer.NewReader(iotest.OneByteReader(strings.NewReader(input))))
+	var got []string
+	for stmt, ok := p.Next(); ok; stmt, ok = p.Next() {
+		got = append(got, stmt.String())
+		if len(got) == 2 && len(p.Errors()) != 1 {
+			t.Errorf("error not reported after the statement. got=%v", p.Errors())
+		}
+	}
+
+	want := []string{"let x = 1;", "<bad statement>", "(x + 1)"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("wrong statements (-want +got):\n%s", diff)
+	}
+	if _, ok := p.Next(); ok {
+		t.Errorf("Next returned a statement after the end of the input")
+	}
+}
+
+func TestNextComments(t *testing.T) {
+	input := "// one\nlet x = 1; /* two */\nx /* three */ + 1\n// four"
+
+	p := New(lexer.New(input))
+	var got [][]string
+	for _, ok := p.Next(); ok; _, ok = p.Next() {
+		var texts []string
+		for _, c := range p.Comments() {
+			texts = append(texts, c.Text)
+		}
+		got = append(got, texts)
+	}
+
+	want := [][]string{{"// one", "/* two */"}, {"/* three */", "// four"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("wrong comments (-want +got):\n%s", diff)
+	}
+	if c := p.Comments(); c != nil {
+		t.Errorf("comments returned after the end of the input. got=%v", c)
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`match (x) { 1 => "one", _ => "other" }`, `match x {1 => one, _ => other}`},
+		{"match (x) { -1 => a, 2.5 => b, true => c, null => d, }", "match x {(-1) => a, 2.5 => b, true => c, null => d}"},
+		{"match (f(x)) { [a, [b, _], ...r] => a + b }", "match f(x) {[a, [b, _], ...r] => (a + b)}"},
+		{`match (m) { {kind: "a", v} if v > 1 => v, {kind} => kind }`, "match m {{kind: a, v} if (v > 1) => v, {kind} => kind}"},
+		{"match (x) { n if n < 0 => { -n } n => { n } }", "match x {n if (n < 0) => (-n), n => n}"},
+		{"match (x) {}", "match x {}"},
+		{`match (x) { 1 => ({"kind": "a"}), _ => { "b" } }`, "match x {1 => {kind:a}, _ => b}"},
+	}
+
+	for _, tt := range tests {
+		prog, err := New(lexer.New(tt.input)).ParseProgram()
+		if err != nil {
+			t.Errorf("%q: failed to parse program: err: %v", tt.input, err)
+			continue
+		}
+		if len(prog.Statements) != 1 {
+			t.Errorf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(prog.Statements))
+			continue
+		}
+		if got := prog.Statements[0].String(); got != tt.want {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestBadMatchExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"match x { _ => 1 }", "1:7: expected next token to be (, got IDENT instead"},
+		{"match (x) { a + 1 => 1 }", "1:15: expected next token to be =>, got + instead"},
+		{"match (x) { f(a) => 1 }", "1:14: expected next token to be =>, got ( instead"},
+		{"match (x) { -a => 1 }", "1:13: expected a pattern, got -"},
+		{"match (x) { 1 => a 2 => b }", "1:20: expected next token to be ,, got INT instead"},
+		{"match (x) { [1, ...2] => a }", "1:20: expected next token to be IDENT, got INT instead"},
+		{"match (x) { 1 if => a }", "1:18: expected an expression, got =>"},
+		{`match (x) { 1 => {"kind": "a"} }`, "1:25: expected an expression, got :"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(lexer.New(tt.input)).ParseProgram()
+		if err == nil {
+			t.Errorf("%q: expected parse error but none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error does not contain %q. got=%q", tt.input, tt.want, err)
+		}
+	}
+}
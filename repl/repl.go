@@ -6,6 +6,7 @@ import (
 	"io"
 	"iscript/compiler"
 	"iscript/lexer"
+	"iscript/module"
 	"iscript/object"
 	"iscript/parser"
 	"iscript/vm"
@@ -22,6 +23,8 @@ func Start(in io.Reader, out io.Writer) {
 	for i, v := range object.Builtins {
 		symTable.DefineBuiltin(i, v.Name)
 	}
+	modules := compiler.NewModules(module.Dir("."), nil)
+	ran := vm.Modules{}
 
 	for {
 		fmt.Fprint(out, PROMPT)
@@ -41,16 +44,20 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		c := compiler.NewWithState(symTable, constants)
+		c.SetModules(modules)
 		err = c.Compile(prog)
+		// The modules compiled before an error are in the constants.
+		constants = c.Bytecode().Constants
 		if err != nil {
 			fmt.Fprintf(out, "Whoops!: Compile failed:\n %s\n", err)
 			continue
 		}
+		for _, w := range c.Warnings() {
+			fmt.Fprintf(out, "warning: %s\n", w)
+		}
 
-		code := c.Bytecode()
-		constants = code.Constants
-
-		machine := vm.NewWithGlobalsStore(code, globals)
+		machine := vm.NewWithGlobalsStore(c.Bytecode(), globals)
+		machine.SetModules(ran)
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Whoops!: Bytecode excecution failed:\n %s\n", err)
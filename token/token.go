@@ -1,21 +1,55 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // first character of the token
+	End     Position // just past the last character of the token
+}
+
+// Position is a location in the source. Lines and columns start at 1, the
+// byte offset starts at 0.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 const (
-	ILLEGAL = "ILLEGAL"
+	ILLEGAL = "ILLEGAL" // Literal describes the problem
 	EOF     = "EOF"
+	COMMENT = "COMMENT"
 
 	// Idents / Literals
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
+	// RAW_STRING is a `backtick` string. Its literal is the text between the
+	// backticks, with common indentation removed when the opening backtick
+	// ends its line.
+	RAW_STRING = "RAW_STRING"
+
+	// An interpolated string "a${x}b${y}c" is lexed as STRING_HEAD("a"), the
+	// tokens of x, STRING_MIDDLE("b"), the tokens of y, STRING_TAIL("c").
+	STRING_HEAD   = "STRING_HEAD"
+	STRING_MIDDLE = "STRING_MIDDLE"
+	STRING_TAIL   = "STRING_TAIL"
+
 	// Operators
 	ASSIGN = "="
 	PLUS   = "+"
@@ -23,15 +57,47 @@ const (
 	BANG   = "!"
 	SPLAT  = "*"
 	SLASH  = "/"
+	MOD    = "%"
+	POW    = "**"
+
+	BIT_AND = "&"
+	BIT_OR  = "|"
+	BIT_XOR = "^"
+	BIT_NOT = "~"
+	SHL     = "<<"
+	SHR     = ">>"
 
-	LT = "<"
-	GT = ">"
+	LT  = "<"
+	GT  = ">"
+	LTE = "<="
+	GTE = ">="
+
+	// Compound assignment, x += 1 is x = x + 1
+	PLUS_ASSIGN    = "+="
+	MINUS_ASSIGN   = "-="
+	SPLAT_ASSIGN   = "*="
+	SLASH_ASSIGN   = "/="
+	MOD_ASSIGN     = "%="
+	POW_ASSIGN     = "**="
+	BIT_AND_ASSIGN = "&="
+	BIT_OR_ASSIGN  = "|="
+	BIT_XOR_ASSIGN = "^="
+	SHL_ASSIGN     = "<<="
+	SHR_ASSIGN     = ">>="
 
 	EQ  = "=="
 	NEQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
+	ELLIPSIS = "..."
+	ARROW    = "=>"
+	PIPE     = "|>"
+
 	// Delims
-	COMMA     = "."
+	COMMA     = ","
+	DOT       = "."
 	COLON     = ":"
 	SEMICOLON = ";"
 
@@ -51,17 +117,33 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	IN       = "IN"
+	MATCH    = "MATCH"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"null":   NULL,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"null":     NULL,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"in":       IN,
+	"match":    MATCH,
+	"import":   IMPORT,
+	"export":   EXPORT,
 }
 
 func LookupIdentifier(ident string) TokenType {
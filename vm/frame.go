@@ -9,6 +9,9 @@ type Frame struct {
 	cl      *object.Closure
 	ip      int
 	basePtr int
+	// module is set on the frame running the top level of an imported
+	// module.
+	module *object.CompiledModule
 }
 
 func NewFrame(cl *object.Closure, basePtr int) *Frame {
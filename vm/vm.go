@@ -5,14 +5,16 @@ import (
 	"iscript/code"
 	"iscript/compiler"
 	"iscript/object"
+	"math"
+	"strings"
 )
 
 const StackSize = 4096
 const GlobalSize = 65536
 const MaxFrames = 1024
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
+var True = object.True
+var False = object.False
 var Null = &object.Null{}
 
 type VM struct {
@@ -21,14 +23,25 @@ type VM struct {
 	stack []object.Object
 	sp    int // Next value. Top is sp-1
 
+	// globals are the globals of the module of the current frame.
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+
+	// modules holds the imported modules, each run once.
+	modules Modules
 }
 
+// Modules maps the modules run by a VM to their values.
+type Modules map[*object.CompiledModule]*object.Module
+
 func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobalsStore(bytecode, make([]object.Object, GlobalSize))
+}
+
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	mainFn := &object.CompiledFunc{Instructions: bytecode.Instructions}
-	mainClosure := &object.Closure{Fn: mainFn}
+	mainClosure := &object.Closure{Fn: mainFn, Globals: s}
 	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
@@ -39,17 +52,20 @@ func New(bytecode *compiler.Bytecode) *VM {
 
 		stack:   make([]object.Object, StackSize),
 		sp:      0,
-		globals: make([]object.Object, GlobalSize),
+		globals: s,
 
 		frames:      frames,
 		framesIndex: 1,
+
+		modules: Modules{},
 	}
 }
 
-func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = s
-	return vm
+// SetModules makes the VM share m with the VMs that used it before, so the
+// modules they ran are not run again. Like the globals, it is used to run the
+// lines of a REPL session.
+func (vm *VM) SetModules(m Modules) {
+	vm.modules = m
 }
 
 func (vm *VM) StackTop() object.Object {
@@ -79,7 +95,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -96,7 +113,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterEqual,
+			code.OpLessThan, code.OpLessEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -111,6 +129,11 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpBitNot:
+			err := vm.executeBitNotOperator()
+			if err != nil {
+				return err
+			}
 		case code.OpJmp:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
@@ -135,7 +158,18 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.pushVar(vm.globals[globalIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpConcat:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str := vm.buildString(vm.sp-numParts, vm.sp)
+			vm.sp = vm.sp - numParts
+
+			err := vm.push(str)
 			if err != nil {
 				return err
 			}
@@ -172,6 +206,121 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpMember:
+			nameIdx := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.executeMember(vm.pop(), vm.constants[nameIdx].(*object.String))
+			if err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpDup:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			for _, o := range vm.stack[vm.sp-n : vm.sp] {
+				err := vm.push(o)
+				if err != nil {
+					return err
+				}
+			}
+		case code.OpIterInit:
+			coll := vm.pop()
+			iterable, ok := coll.(object.Iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", coll.Type())
+			}
+
+			err := vm.push(&iterator{iterable.Iter()})
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			exit := int(code.ReadUint16(ins[ip+1:]))
+			numValues := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			key, val, ok := vm.stack[vm.sp-1].(*iterator).Next()
+			if !ok {
+				vm.currentFrame().ip = exit - 1
+				continue
+			}
+			if numValues == 2 {
+				err := vm.push(key)
+				if err != nil {
+					return err
+				}
+			}
+			err := vm.push(val)
+			if err != nil {
+				return err
+			}
+		case code.OpCheckArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			hasRest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			err := checkArrayShape(vm.stack[vm.sp-1], numElements, hasRest)
+			if err != nil {
+				return err
+			}
+		case code.OpCheckHash:
+			numKeys := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			keys := vm.stack[vm.sp-numKeys : vm.sp]
+			vm.sp -= numKeys
+
+			err := checkHashShape(vm.stack[vm.sp-1], keys)
+			if err != nil {
+				return err
+			}
+		case code.OpMatchArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			hasRest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			err := vm.push(nativeBoolToBoolObject(checkArrayShape(vm.pop(), numElements, hasRest) == nil))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchHash:
+			numKeys := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			keys := vm.stack[vm.sp-numKeys : vm.sp]
+			vm.sp -= numKeys
+
+			err := vm.push(nativeBoolToBoolObject(checkHashShape(vm.pop(), keys) == nil))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchValue:
+			pattern := vm.pop()
+			val := vm.pop()
+
+			err := vm.push(nativeBoolToBoolObject(object.MatchesValue(pattern, val)))
+			if err != nil {
+				return err
+			}
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSlice(left, low, high)
+			if err != nil {
+				return err
+			}
 		case code.OpCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
@@ -180,13 +329,21 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpCallSpread:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeSpreadCall(int(numArgs))
+			if err != nil {
+				return err
+			}
 		case code.OpRetVal:
 			retVal := vm.pop()
 
 			frame := vm.popFrame()
 			vm.sp = frame.basePtr - 1
 
-			err := vm.push(retVal)
+			err := vm.push(vm.returnValue(frame, retVal))
 			if err != nil {
 				return err
 			}
@@ -194,7 +351,15 @@ func (vm *VM) Run() error {
 			frame := vm.popFrame()
 			vm.sp = frame.basePtr - 1
 
-			err := vm.push(Null)
+			err := vm.push(vm.returnValue(frame, Null))
+			if err != nil {
+				return err
+			}
+		case code.OpImport:
+			constIdx := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.importModule(vm.constants[constIdx])
 			if err != nil {
 				return err
 			}
@@ -204,14 +369,36 @@ func (vm *VM) Run() error {
 
 			frame := vm.currentFrame()
 
-			vm.stack[frame.basePtr+int(localIdx)] = vm.pop()
+			slot := &vm.stack[frame.basePtr+int(localIdx)]
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
 		case code.OpGetLocal:
 			localIdx := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePtr+int(localIdx)])
+			err := vm.pushVar(deref(vm.stack[frame.basePtr+int(localIdx)]))
+			if err != nil {
+				return err
+			}
+		case code.OpCaptureLocal:
+			localIdx := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+
+			slot := &vm.stack[frame.basePtr+int(localIdx)]
+			cell, ok := (*slot).(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
+
+			err := vm.push(cell)
 			if err != nil {
 				return err
 			}
@@ -239,7 +426,25 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip += 1
 
 			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[idx])
+			err := vm.pushVar(deref(currentClosure.Free[idx]))
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			idx := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free
+			if cell, ok := free[idx].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				free[idx] = vm.pop()
+			}
+		case code.OpCaptureFree:
+			idx := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.push(vm.currentFrame().cl.Free[idx])
 			if err != nil {
 				return err
 			}
@@ -254,6 +459,22 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// iterator holds the state of a for-in loop on the stack.
+type iterator struct {
+	object.Iterator
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// deref returns the value held by obj if it is a cell, or obj itself.
+func deref(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
+	return obj
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
@@ -275,6 +496,22 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	return &object.Array{Elements: elements}
 }
 
+// buildString concatenates the stack values between the indices. Strings are
+// used as is, everything else as its Inspect output.
+func (vm *VM) buildString(startIndex, endIndex int) object.Object {
+	var out strings.Builder
+
+	for i := startIndex; i < endIndex; i++ {
+		if str, ok := vm.stack[i].(*object.String); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString(vm.stack[i].Inspect())
+		}
+	}
+
+	return &object.String{Value: out.String()}
+}
+
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 	hashedPairs := make(map[object.HashKey]object.HashPair)
 
@@ -298,25 +535,47 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		return vm.executeModuleExport(left.(*object.Module), index.(*object.String).Value)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+func (vm *VM) executeModuleExport(m *object.Module, name string) error {
+	val, ok := m.Exports[name]
+	if !ok {
+		return fmt.Errorf("module %s has no export %s", m.Path, name)
+	}
+	return vm.push(val)
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObj := array.(*object.Array)
-	i := index.(*object.Integer).Value
-	max := int64(len(arrayObj.Elements) - 1)
-
-	if i < 0 || i > max {
+	i, ok := object.ElementIndex(index.(*object.Integer).Value, len(arrayObj.Elements))
+	if !ok {
 		return vm.push(Null)
 	}
 
 	return vm.push(arrayObj.Elements[i])
 }
 
+// executeStringIndex pushes the character of a string at index, counting in
+// runes.
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	s := str.(*object.String)
+	i, ok := object.ElementIndex(index.(*object.Integer).Value, s.Len())
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: s.Substr(int(i), int(i)+1)})
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObj := hash.(*object.Hash)
 
@@ -332,13 +591,104 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+// executeSetIndex stores value in an array element or hash entry and pushes
+// value back as the result of the assignment.
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		idx, ok := object.ElementIndex(i.Value, len(left.Elements))
+		if !ok {
+			return fmt.Errorf("index out of range: %d with length %d", i.Value, len(left.Elements))
+		}
+		left.Elements[idx] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
+// executeSlice pushes the elements of an array, or the characters of a
+// string, from low up to high.
+func (vm *VM) executeSlice(left, low, high object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		lo, hi, err := object.SliceBounds(low, high, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		elements := make([]object.Object, hi-lo)
+		copy(elements, left.Elements[lo:hi])
+		return vm.push(&object.Array{Elements: elements})
+	case *object.String:
+		lo, hi, err := object.SliceBounds(low, high, left.Len())
+		if err != nil {
+			return err
+		}
+		return vm.push(&object.String{Value: left.Substr(int(lo), int(hi))})
+	default:
+		return fmt.Errorf("slice operator not supported: %s", left.Type())
+	}
+}
+
+// checkArrayShape reports an error unless obj is an array with numElements
+// elements, or at least that many when a rest pattern takes the others.
+func checkArrayShape(obj object.Object, numElements int, hasRest bool) error {
+	arr, ok := obj.(*object.Array)
+	if !ok {
+		return fmt.Errorf("cannot destructure %s as ARRAY", obj.Type())
+	}
+	if hasRest && len(arr.Elements) < numElements {
+		return fmt.Errorf("not enough values to destructure: want at least %d, got %d", numElements, len(arr.Elements))
+	}
+	if !hasRest && len(arr.Elements) != numElements {
+		return fmt.Errorf("wrong number of values to destructure: want %d, got %d", numElements, len(arr.Elements))
+	}
+	return nil
+}
+
+// checkHashShape reports an error unless obj is a hash with all of keys.
+func checkHashShape(obj object.Object, keys []object.Object) error {
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		return fmt.Errorf("cannot destructure %s as HASH", obj.Type())
+	}
+	for _, k := range keys {
+		if _, ok := hash.Pairs[k.(object.Hashable).HashKey()]; !ok {
+			return fmt.Errorf("missing key to destructure: %s", k.Inspect())
+		}
+	}
+	return nil
+}
+
 func (vm *VM) executeMinusOperator() error {
+	switch operand := vm.pop().(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
+	}
+}
+
+func (vm *VM) executeBitNotOperator() error {
 	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	integer, ok := operand.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("unknown operator: ~%s", operand.Type())
 	}
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(&object.Integer{Value: ^integer.Value})
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -362,6 +712,9 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -369,8 +722,17 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBoolObject(right != left))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return operatorError(op, left, right)
+	}
+}
+
+// pushVar pushes the value of a variable, which is nil until it is set: a
+// function can refer to a variable declared after it in its block.
+func (vm *VM) pushVar(o object.Object) error {
+	if o == nil {
+		return fmt.Errorf("variable used before it is set")
 	}
+	return vm.push(o)
 }
 
 func (vm *VM) push(o object.Object) error {
@@ -404,16 +766,18 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+		return operatorError(op, left, right)
 	}
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return operatorError(op, left, right)
 	}
 
 	leftVal := left.(*object.String).Value
@@ -438,9 +802,37 @@ func (vm *VM) executeBinaryIntegerOperation(
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
+	case code.OpPow:
+		if rightValue < 0 {
+			return vm.push(&object.Float{Value: math.Pow(float64(leftValue), float64(rightValue))})
+		}
+		result = object.IntPow(leftValue, rightValue)
+	case code.OpBitAnd:
+		result = leftValue & rightValue
+	case code.OpBitOr:
+		result = leftValue | rightValue
+	case code.OpBitXor:
+		result = leftValue ^ rightValue
+	case code.OpShl, code.OpShr:
+		if rightValue < 0 {
+			return fmt.Errorf("negative shift count: %d", rightValue)
+		}
+		if op == code.OpShl {
+			result = leftValue << rightValue
+		} else {
+			result = leftValue >> rightValue
+		}
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return operatorError(op, left, right)
 	}
 	return vm.push(&object.Integer{Value: result})
 }
@@ -456,9 +848,96 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBoolObject(rightVal != leftVal))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBoolObject(leftVal > rightVal))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBoolObject(leftVal >= rightVal))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBoolObject(leftVal < rightVal))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBoolObject(leftVal <= rightVal))
+	default:
+		return operatorError(op, left, right)
+	}
+}
+
+// executeBinaryFloatOperation handles floats and mixed integer and float
+// operands. The integer side is widened to a float.
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
+
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	case code.OpPow:
+		result = math.Pow(leftValue, rightValue)
+	default:
+		return operatorError(op, left, right)
+	}
+	return vm.push(&object.Float{Value: result})
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftVal := object.ToFloat(left)
+	rightVal := object.ToFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBoolObject(rightVal == leftVal))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBoolObject(rightVal != leftVal))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBoolObject(leftVal > rightVal))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBoolObject(leftVal >= rightVal))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBoolObject(leftVal < rightVal))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBoolObject(leftVal <= rightVal))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return operatorError(op, left, right)
+	}
+}
+
+// operators maps the infix opcodes back to the operators they come from.
+var operators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShl:          "<<",
+	code.OpShr:          ">>",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+}
+
+// operatorError reports an infix operator that does not apply to its
+// operands, in the same words as the evaluator.
+func operatorError(op code.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() && !(object.IsNumber(left) && object.IsNumber(right)) {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
 	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
 }
 
 func nativeBoolToBoolObject(input bool) *object.Boolean {
@@ -475,26 +954,125 @@ func (vm *VM) currentFrame() *Frame {
 func (vm *VM) pushFrame(f *Frame) {
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	vm.globals = f.cl.Globals
 }
 
 func (vm *VM) popFrame() *Frame {
 	vm.framesIndex--
+	vm.globals = vm.frames[vm.framesIndex-1].cl.Globals
 	return vm.frames[vm.framesIndex]
 }
 
+// importModule pushes the module in a constant. A compiled module is run
+// first if it was not imported before, its frame returns the module, see
+// returnValue. A module from a registry is pushed as it is.
+func (vm *VM) importModule(constant object.Object) error {
+	cm, ok := constant.(*object.CompiledModule)
+	if !ok {
+		return vm.push(constant)
+	}
+	if m, ok := vm.modules[cm]; ok {
+		return vm.push(m)
+	}
+
+	cl := &object.Closure{Fn: cm.Fn, Globals: make([]object.Object, cm.NumGlobals)}
+	err := vm.push(cl)
+	if err != nil {
+		return err
+	}
+	frame := NewFrame(cl, vm.sp)
+	frame.module = cm
+	vm.pushFrame(frame)
+	return nil
+}
+
+// returnValue is the value of the call that ran frame and returned retVal.
+// The frame of a module evaluates to the module, with its exports read from
+// its globals as they are when it finishes.
+func (vm *VM) returnValue(frame *Frame, retVal object.Object) object.Object {
+	if frame.module == nil {
+		return retVal
+	}
+
+	m := &object.Module{Path: frame.module.Path, Exports: map[string]object.Object{}}
+	for name, idx := range frame.module.Exports {
+		m.Exports[name] = frame.cl.Globals[idx]
+	}
+	vm.modules[frame.module] = m
+	return m
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
-	if numArgs != cl.Fn.NumParams {
-		return fmt.Errorf("wrong number of args: want=%d, got=%d", cl.Fn.NumParams, numArgs)
+	fn := cl.Fn
+	if numArgs < fn.MinArity || !fn.Variadic() && numArgs > fn.MaxArity {
+		return wrongArgs(fn, numArgs)
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
+
+	var rest *object.Array
+	if fn.Variadic() {
+		rest = &object.Array{Elements: []object.Object{}}
+		if numArgs > fn.NumParams {
+			rest.Elements = append(rest.Elements, vm.stack[frame.basePtr+fn.NumParams:vm.sp]...)
+			numArgs = fn.NumParams
+		}
+	}
+	if numArgs < fn.NumParams {
+		frame.ip = fn.Defaults[numArgs-fn.MinArity] - 1
+	}
+
 	vm.pushFrame(frame)
 
-	vm.sp = frame.basePtr + cl.Fn.NumLocals
+	vm.sp = frame.basePtr + fn.NumLocals
+	// Clear the locals, a cell left behind by an earlier call must not be
+	// written through by this one.
+	for i := frame.basePtr + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+	if rest != nil {
+		vm.stack[frame.basePtr+fn.NumParams] = rest
+	}
 
 	return nil
 }
 
+func wrongArgs(fn *object.CompiledFunc, numArgs int) error {
+	switch {
+	case fn.Variadic():
+		return fmt.Errorf("wrong number of args: want at least %d, got=%d", fn.MinArity, numArgs)
+	case fn.MinArity != fn.MaxArity:
+		return fmt.Errorf("wrong number of args: want=%d to %d, got=%d", fn.MinArity, fn.MaxArity, numArgs)
+	default:
+		return fmt.Errorf("wrong number of args: want=%d, got=%d", fn.MaxArity, numArgs)
+	}
+}
+
+// executeSpreadCall replaces the numArgs arrays on the stack with their
+// elements and calls the function below them.
+func (vm *VM) executeSpreadCall(numArgs int) error {
+	groups := make([]object.Object, numArgs)
+	copy(groups, vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp -= numArgs
+
+	total := 0
+	for _, g := range groups {
+		arr, ok := g.(*object.Array)
+		if !ok {
+			return fmt.Errorf("cannot spread %s", g.Type())
+		}
+		for _, el := range arr.Elements {
+			err := vm.push(el)
+			if err != nil {
+				return err
+			}
+		}
+		total += len(arr.Elements)
+	}
+
+	return vm.executeCall(total)
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -502,11 +1080,32 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.BoundMethod:
+		return vm.callMethod(callee, numArgs)
 	default:
 		return fmt.Errorf("calling non-function and non-built-in %T", callee)
 	}
 }
 
+// executeMember pushes left.name, which is a field of a hash, or else one of
+// the methods of left's type.
+func (vm *VM) executeMember(left object.Object, name *object.String) error {
+	if m, ok := left.(*object.Module); ok {
+		return vm.executeModuleExport(m, name.Value)
+	}
+	if hash, ok := left.(*object.Hash); ok {
+		if pair, ok := hash.Pairs[name.HashKey()]; ok {
+			return vm.push(pair.Value)
+		}
+		if object.LookupMethod(hash, name.Value) == nil {
+			return vm.push(Null)
+		}
+	} else if object.LookupMethod(left, name.Value) == nil {
+		return fmt.Errorf("undefined method %s for %s", name.Value, left.Type())
+	}
+	return vm.push(&object.BoundMethod{Receiver: left, Name: name.Value})
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
@@ -522,6 +1121,24 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// callMethod calls the method of m's receiver, which takes the place of the
+// callee on the stack as the method's first argument.
+func (vm *VM) callMethod(m *object.BoundMethod, numArgs int) error {
+	method := object.LookupMethod(m.Receiver, m.Name)
+	if method == nil {
+		return fmt.Errorf("undefined method %s for %s", m.Name, m.Receiver.Type())
+	}
+
+	vm.stack[vm.sp-1-numArgs] = m.Receiver
+	res := method.Fn(vm.stack[vm.sp-1-numArgs : vm.sp]...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if res != nil {
+		return vm.push(res)
+	}
+	return vm.push(Null)
+}
+
 func (vm *VM) pushClosure(constIdx, numFree int) error {
 	constant := vm.constants[constIdx]
 	fn, ok := constant.(*object.CompiledFunc)
@@ -535,6 +1152,6 @@ func (vm *VM) pushClosure(constIdx, numFree int) error {
 	}
 	vm.sp -= numFree
 
-	closure := &object.Closure{Fn: fn, Free: free}
+	closure := &object.Closure{Fn: fn, Free: free, Globals: vm.globals}
 	return vm.push(closure)
 }
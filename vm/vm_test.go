@@ -5,8 +5,11 @@ import (
 	"iscript/ast"
 	"iscript/compiler"
 	"iscript/lexer"
+	"iscript/module"
 	"iscript/object"
 	"iscript/parser"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -15,6 +18,30 @@ type vmTestCase struct {
 	expected interface{}
 }
 
+// testModules can be imported by the inputs of the tests.
+var testModules = module.Map{
+	"counter.is":    "let count = 0; export let next = fn() { count += 1; count };",
+	"pair.is":       `export let [a, {b}] = [1, {"b": 2}];`,
+	"hoist.is":      "export let v = twice(2); export fn twice(x) { x * 2 }",
+	"bump.is":       "let counter = 0; export fn bump() { counter += step; counter } let step = 2;",
+	"lib/math.is":   `let c = import("./consts.is"); export let tau = c.pi * 2; export fn double(x) { x * 2 }`,
+	"lib/consts.is": "export let pi = 3;",
+	"lib/db.is":     `let db = import("myapp/db"); export let table = db.name + "s";`,
+	"snapshot.is":   "export let n = 0; export fn inc() { n += 1; n }",
+}
+
+// testRegistry holds the Go modules the inputs of the tests can import.
+var testRegistry = func() *module.Registry {
+	r := module.NewRegistry()
+	r.Register("strings", map[string]object.Object{
+		"repeat": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.Repeat(args[0].(*object.String).Value, int(args[1].(*object.Integer).Value))}
+		}},
+	})
+	r.Register("myapp/db", map[string]object.Object{"name": &object.String{Value: "user"}})
+	return r
+}()
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 	for _, tt := range tests {
@@ -24,6 +51,8 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 		}
 
 		comp := compiler.New()
+		comp.SetLoader(testModules)
+		comp.SetRegistry(testRegistry)
 		err = comp.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -54,6 +83,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObj failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObj failed: %s", err)
+		}
 	case bool:
 		err := testBoolObject(bool(expected), actual)
 		if err != nil {
@@ -106,6 +140,12 @@ func testExpectedObject(
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case object.ObjectType:
+		// Only the type is checked, for values such as closures that
+		// cannot be written as Go values.
+		if actual == nil || actual.Type() != expected {
+			t.Errorf("object has wrong type. got=%T (%+v), want=%s", actual, actual, expected)
+		}
 	case *object.Error:
 		errObj, ok := actual.(*object.Error)
 		if !ok {
@@ -147,6 +187,17 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+	return nil
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -165,6 +216,46 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 ** 0", 1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"~5", -6},
+		{"1 + 2 << 1", 6},
+		{"1 | 2 ^ 3 & 4", 3},
+		{"1234567 % 16 & 7", 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+		{"let total = 40; let part = 10; part * 100.0 / total", 25.0},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"2 == 2.0", true},
+		{"2.5 != 2.5", false},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"2 ** -1", 0.5},
+		{"2.0 ** 3", 8.0},
+		{"1.5 <= 1.5", true},
+		{"2 >= 2.5", false},
 	}
 
 	runVmTests(t, tests)
@@ -208,6 +299,94 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if(false) {5;})", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
+		{"2 < 1", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = x + 1; x", 2},
+		{"let a = 1; let b = 2; a = b = 5; a + b", 10},
+		{"let x = 1; (x = 7) + x", 14},
+		{"let f = fn() { let x = 1; x = x * 10; x }; f()", 10},
+		{"let f = fn(x) { x = x + 1; x }; f(1)", 2},
+		{"let g = 1; let f = fn() { g = g + 1 }; f(); f(); g", 3},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()", 3},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let a = counter(); let b = counter(); a(); a(); b()", 1},
+		{"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f()", 2},
+		{"let f = fn() { let n = 1; let get = fn() { n }; n = 5; get() }; f()", 5},
+		{"let f = fn() { let n = 0; fn() { fn() { n = n + 1; n } } }; let g = f()(); g(); g()", 2},
+		{"let mk = fn(v) { let n = v; fn() { n } }; let a = mk(1); let b = mk(2); a() + b() * 10", 21},
+		{"let f = fn() { f = 1; f }; f()", 1},
+		{"let f = fn() { let g = fn() { f = 3 }; g(); f }; f()", 3},
+		{"let g = fn() { let f = fn() { f = f + 1; f }; let r = f; f = 5; r() }; g()", 6},
+		{"fn f() { f = 2; 0 } f(); f", 2},
+		{"let f = fn(n) { if (n == 0) { f = 7; 0 } else { f(n - 1) } }; f(2); f", 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAndCompoundAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[1] = 5; a", []int{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[0] = a[1] = 9; a", []int{9, 9, 3}},
+		{"let a = [1, 2, 3]; let b = a; b[2] = 0; a", []int{1, 2, 0}},
+		{"let a = [1, 2, 3]; a[2] += 10", 13},
+		{"let a = [[1], [2]]; a[1][0] -= 5; a[1][0]", -3},
+		{"let h = {}; h[\"k\"] = 1; h[\"k\"]", 1},
+		{"let h = {\"k\": 2}; h[\"k\"] **= 3; h[\"k\"]", 8},
+		{"let h = {}; h[1] = 1; h[true] = 2; h[1] + h[true]", 3},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x %= 4", 2},
+		{"let x = 1; x <<= 4; x |= 1; x ^= 3; x &= 6; x >>= 1", 1},
+		{"let s = \"a\"; s += \"b\"; s", "ab"},
+		{"let x = 1; x += (x = 5); x", 6},
+		{"let f = fn() { let n = 1; n += 2; n }; f()", 3},
+		{"let mk = fn() { let n = 0; fn() { n += 1 } }; let c = mk(); c(); c()", 2},
+		{"let i = 0; let next = fn() { i += 1; i }; let a = [0, 0, 0]; a[next()] += 7; [i, a[1]]", []int{1, 7}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1]; a[1] = 2", "index out of range: 1 with length 1"},
+		{"let a = [1]; a[-2] = 2", "index out of range: -2 with length 1"},
+		{"let a = [1]; a[\"x\"] = 2", "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn() {}] = 1", "unusable as hash key: CLOSURE_OBJ"},
+		{"let s = \"abc\"; s[0] = \"x\"", "index assignment not supported: STRING"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"true || false", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"1 && null", false},
+		{"null || 0", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && 1()", false},
+		{"true || 1()", true},
+		{"true && false || true", true},
+		{"!(true && false)", true},
+		{"if (1 < 2 && 3 > 2) {10} else {20}", 10},
 	}
 
 	runVmTests(t, tests)
@@ -225,11 +404,196 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) {10}", Null},
 		{"if (false) {10}", Null},
 		{"if ((if (false) {10})) {10} else {20}", 20},
+		{"if (false) {10} else if (true) {20} else {30}", 20},
+		{"if (false) {10} else if (false) {20} else {30}", 30},
+		{"if (false) {10} else if (false) {20}", Null},
+		{"let x = 3; if (x == 1) {10} else if (x == 2) {20} else if (x == 3) {30} else {40}", 30},
+		{"let f = fn(n) { if (n < 0) { return -1 } else if (n == 0) { 0 } else { 1 } }; [f(-5), f(0), f(5)]", []int{-1, 0, 1}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; let sum = 0; while (i < 5) { sum += i; i += 1; } sum", 10},
+		{"let sum = 0; for (let i = 1; i <= 10; i += 1) { sum += i } sum", 55},
+		{"let n = 0; while (true) { n += 1; if (n == 7) { break; } } n", 7},
+		{"let n = 0; for (;;) { n += 1; if (n > 3) { break } } n", 4},
+		{"let sum = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } sum += i; } sum", 25},
+		{"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i % 3 != 0) { continue } sum += i } sum", 18},
+		{"let n = 0; for (let i = 0; i < 3; i += 1) { for (let j = 0; j < 10; j += 1) { if (j == 2) { break } n += 1 } } n", 6},
+		{"let f = fn(n) { let r = 1; while (n > 1) { r *= n; n -= 1 } r }; f(5)", 120},
+		{"let find = fn(a, x) { for (let i = 0; i < len(a); i += 1) { if (a[i] == x) { return i } } -1 }; find([4, 5, 6], 6) + find([1], 9)", 1},
+		{"let fs = []; for (let i = 0; i < 3; i += 1) { fs = push(fs, fn() { i }) } fs[0]()", 3},
+		{"let x = 0; while (false) { x = 1 } x", 0},
+		{"let x = 0; for (; x < 3;) { if (true) { let y = 1; x += y; } } x", 3},
+		{"let i = 0; while (i < 2) { i += 1 }", Null},
+		{"while (true) { break }", Null},
+		{"for (let i = 0; i < 2; i += 1) { i }", Null},
+		{"for (;;) { break }", Null},
+		{"for (x in [1, 2]) { x }", Null},
+		{"1; while (false) {}", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForIn(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x } sum", 6},
+		{"let sum = 0; for (i, x in [5, 6, 7]) { sum += i * x } sum", 20},
+		{`let s = ""; for (c in "héllo") { s = c + s } s`, "olléh"},
+		{`let s = ""; for (i, c in "ab") { s += "${i}${c}" } s`, "0a1b"},
+		{`let s = ""; for (k, v in {"b": 2, "a": 1, "c": 3}) { s += "${k}${v}" } s`, "a1b2c3"},
+		{`let s = ""; for (v in {2: "x", 1: "y", true: "z"}) { s += v } s`, "zyx"},
+		{"let sum = 0; for (i in range(5)) { sum += i } sum", 10},
+		{"let sum = 0; for (i in range(2, 11, 4)) { sum += i } sum", 18},
+		{"let a = []; for (i in range(3, 0, -1)) { a = push(a, i) } a", []int{3, 2, 1}},
+		{"let n = 0; for (x in []) { n += 1 } n", 0},
+		{"let sum = 0; for (x in [1, 2, 3, 4, 5]) { if (x == 2) { continue } if (x == 4) { break } sum += x } sum", 4},
+		{"let n = 0; for (a in [1, 2]) { for (b in [10, 20]) { n += a * b } } n", 90},
+		{"let f = fn(xs) { let m = 0; for (x in xs) { if (x > m) { m = x } } m }; f([3, 9, 2])", 9},
+		{"let find = fn(xs, y) { for (i, x in xs) { if (x == y) { return i } } -1 }; find([7, 8, 9], 9)", 2},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }) } fs[0]() }; f()", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForInErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"for (x in fn() {}) { x }", "cannot iterate over CLOSURE_OBJ"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+type countdown struct {
+	from int64
+}
+
+func (c *countdown) Type() object.ObjectType { return "COUNTDOWN" }
+func (c *countdown) Inspect() string         { return "countdown" }
+func (c *countdown) Iter() object.Iterator   { return &countdownIter{n: c.from} }
+
+type countdownIter struct {
+	n int64
+}
+
+func (it *countdownIter) Next() (object.Object, object.Object, bool) {
+	if it.n == 0 {
+		return nil, nil, false
+	}
+	it.n--
+	return &object.Integer{Value: it.n}, &object.Integer{Value: it.n + 1}, true
+}
+
+// TestLoopControlInExpressions checks that a break, continue or return inside
+// an if or match used as a value leaves the loop or function.
+func TestLoopControlInExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let n = 0; for (i in [1, 2, 3]) { let x = if (i == 2) { break; }; n += 1 } n", "1"},
+		{"let s = 0; for (i in [1, 2, 3]) { s = s + if (i == 2) { continue } else { i } } s", "4"},
+		{"let f = fn(x) { x }; let s = 0; for (i in [1, 2, 3]) { s += f(match (i) { 2 => { break } _ => i }) } s", "1"},
+		{"let n = 0; let i = 0; while (true) { i += 1; let x = if (i == 2) { break; }; n += 1 } n", "1"},
+		{"let s = 0; let i = 0; while (i < 4) { i += 1; s += -if (i % 2 == 0) { continue } else { i } } s", "-4"},
+		{"let g = fn() { let x = if (true) { return 7 }; 0 }; g()", "7"},
+		{"let s = []; for (i in [1, 2, 3]) { s = push(s, [i, if (i > 1) { continue } else { i }]) } s", "[[1, 1]]"},
+		{`let s = ""; for (i in [1, 2, 3]) { s += "${i}${match (i) { 2 => { continue } _ => "," }}" } s`, "1,3,"},
+		{"let n = 0; for (i in [1, 2, 3]) { match (i) { x if (if (x == 3) { break } else { true }) => { n += x } } } n", "3"},
+		{"let h = {1: 0, 2: 0}; for (i in [1, 2]) { h[i] += if (i == 2) { break } else { 1 } } [h[1], h[2]]", "[1, 0]"},
+		{"let i = 0; let s = 0; while (i < 5000) { i += 1; s = s + if (true) { continue } else { 0 } } i", "5000"},
+	}
+
+	for _, tt := range tests {
+		program, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("parser error: %s", err)
+		}
+
+		comp := compiler.New()
+		err = comp.Compile(program)
+		if err != nil {
+			t.Fatalf("%s: compiler error: %s", tt.input, err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("%s: vm error: %s", tt.input, err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestForInHostObject(t *testing.T) {
+	program, err := parse("let s = 0; for (i, x in cd) { s = s * 10 + x } s")
+	if err != nil {
+		t.Fatalf("parser error: %s", err)
+	}
+
+	symTable := compiler.NewSymTable()
+	sym := symTable.Define("cd")
+	globals := make([]object.Object, GlobalSize)
+	globals[sym.Index] = &countdown{from: 3}
+
+	comp := compiler.NewWithState(symTable, []object.Object{})
+	err = comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewWithGlobalsStore(comp.Bytecode(), globals)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, 321, vm.LastPoppedStackElem())
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, ...rest] = [1, 2, 3]; rest", []int{2, 3}},
+		{"let [a, b, ...rest] = [1, 2]; rest", []int{}},
+		{`let {name, age: years} = {"name": "bob", "age": 30}; name + " ${years}"`, "bob 30"},
+		{`let {"first name": f} = {"first name": "ann", "x": 1}; f`, "ann"},
+		{`let [x, {y, z: [z]}] = [1, {"y": 2, "z": [3]}]; x + y + z`, 6},
+		{"let a = 1; let b = 2; let [a, b] = [b, a]; a * 10 + b", 21},
+		{"let f = fn(p) { let [x, y] = p; x - y }; f([5, 3])", 2},
+		{"let f = fn(p) { let {v} = p; fn() { v } }; f({\"v\": 7})()", 7},
+		{"let sum = fn(xs) { if (len(xs) == 0) { return 0 } let [x, ...r] = xs; x + sum(r) }; sum([1, 2, 3, 4])", 10},
+		{"let a = [1, 2, 3]; let [x, ...r] = a; r[0] = 9; a", []int{1, 2, 3}},
+		{"fn() { let [a] = [1]; }()", Null},
+		{"fn() { for (x in [1]) { x } }()", Null},
+		{"if (true) { let {a} = {\"a\": 1}; }", Null},
+		{"if (true) { for (x in [1]) {} }", Null},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestDestructuringErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1]", "wrong number of values to destructure: want 2, got 1"},
+		{"let [a] = [1, 2]", "wrong number of values to destructure: want 1, got 2"},
+		{"let [a, b, ...c] = [1]", "not enough values to destructure: want at least 2, got 1"},
+		{"let [a] = 5", "cannot destructure INTEGER as ARRAY"},
+		{"let {a} = [1]", "cannot destructure ARRAY as HASH"},
+		{`let {a, b} = {"a": 1}`, "missing key to destructure: b"},
+		{`let [{a}] = [{"b": 1}]`, "missing key to destructure: a"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -248,6 +612,26 @@ func TestStringExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`"plain ${"text"}"`, "plain text"},
+		{`let name = "bob"; "hello ${name}!"`, "hello bob!"},
+		{`let items = [1, 2]; "you have ${len(items)} items"`, "you have 2 items"},
+		{`"${1 + 1} ${2.5} ${true} ${null} ${[1, "a"]}"`, "2 2.5 true null [1, a]"},
+		{`let f = fn(x) { "<${x}>" }; "${f("a")}${f("b")}"`, "<a><b>"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{"`a\\b`", `a\b`},
+		{"len(`\n  x\n  `)", 2},
+		{"let q = `\n\tSELECT ${id}\n\t`; q + \"!\"", "SELECT ${id}\n!"},
+	}
+	runVmTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -279,15 +663,90 @@ func TestIndexExpressions(t *testing.T) {
 		{"[[1,1,1]][0][0]", 1},
 		{"[][0]", Null},
 		{"[1,2,3][99]", Null},
-		{"[1][-1]", Null},
+		{"[1][-1]", 1},
+		{"[1][-2]", Null},
 		{"{1: 1, 2:2}[1]", 1},
 		{"{1:1, 2:2}[2]", 2},
 		{"{1:1}[0]", Null},
 		{"{}[0]", Null},
+		{"{1: 5}[1.0]", 5},
+		{"{2.0: 5}[2]", 5},
+		{"{1: 5}[1.5]", Null},
 	}
 	runVmTests(t, tests)
 }
 
+func TestSlices(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+		{"[1, 2, 3][:-1]", []int{1, 2}},
+		{"[1, 2, 3][-2:]", []int{2, 3}},
+		{"[1, 2, 3][:]", []int{1, 2, 3}},
+		{"[1, 2, 3][3:]", []int{}},
+		{"[1, 2, 3][1:1]", []int{}},
+		{"let a = [1, 2]; let b = a[:]; b[0] = 9; a[0]", 1},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[2:]`, "llo"},
+		{`"hello"[:-1]`, "hell"},
+		{`"héllo"[1:2]`, "é"},
+		{`"hello"[0]`, "h"},
+		{`"hello"[-1]`, "o"},
+		{`"héllo"[1]`, "é"},
+		{`let s = "héllo"; s[len(s) - 1]`, "o"},
+		{`let s = "héllo"; s[0:len(s)]`, "héllo"},
+		{`let s = "héllo"; let out = ""; let i = 0; while (i < s.len()) { out = s[i] + out; i += 1 } out`, "olléh"},
+		{`len("héllo")`, 5},
+		{`"hello"[5]`, Null},
+		{"let a = [1, 2, 3]; a[-1] = 9; a", []int{1, 2, 9}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSharedBytecode(t *testing.T) {
+	program, err := parse(`let s = "héllo"; s[1] + s[2:] + s[-1]`)
+	if err != nil {
+		t.Fatalf("parser error: %s", err)
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	// The VMs share the string constant, run with -race to check they do
+	// not write to it.
+	var wg sync.WaitGroup
+	results := make([]object.Object, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			vm := New(bytecode)
+			if err := vm.Run(); err == nil {
+				results[i] = vm.LastPoppedStackElem()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		testExpectedObject(t, "élloo", result)
+	}
+}
+
+func TestSliceErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][2:1]", "slice bounds out of range: [2:1] with length 3"},
+		{"[1, 2, 3][:4]", "slice bounds out of range: [:4] with length 3"},
+		{"[1, 2, 3][-4:]", "slice bounds out of range: [-4:] with length 3"},
+		{`"abc"["a":]`, "slice index must be INTEGER, got STRING"},
+		{"{}[1:]", "slice operator not supported: HASH"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -504,6 +963,52 @@ func TestCallingFuncWithArgAndBind(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func runVmErrorTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+	for _, tt := range tests {
+		prog, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("parser error: %s", err)
+		}
+
+		comp := compiler.New()
+		comp.SetLoader(testModules)
+		comp.SetRegistry(testRegistry)
+		err = comp.Compile(prog)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("%s: expected vm error but none.", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong VM error: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestOperatorErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"5 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"1 < true", "type mismatch: INTEGER < BOOLEAN"},
+		{"true + false", "unknown operator: BOOLEAN + BOOLEAN"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`"a" < "b"`, "unknown operator: STRING < STRING"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -543,6 +1048,269 @@ func TestWrongArguments(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(a, b = 10) { a + b }; f(1) + f(1, 2)", 14},
+		{"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; f(1)", []int{1, 2, 3}},
+		{"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; f(1, 5)", []int{1, 5, 6}},
+		{"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; f(1, 5, 0)", []int{1, 5, 0}},
+		{"let f = fn(...xs) { xs }; f()", []int{}},
+		{"let f = fn(...xs) { xs }; f(1, 2)", []int{1, 2}},
+		{"let f = fn(a, ...xs) { [a, len(xs)] }; f(1, 2, 3)", []int{1, 2}},
+		{"let f = fn(a, b = 2, ...xs) { a + b + len(xs) }; f(1)", 3},
+		{"let f = fn(a, b = 2, ...xs) { a + b + len(xs) }; f(1, 5, 6, 7)", 8},
+		{"let add = fn(a, b, c) { a + b + c }; let xs = [1, 2]; add(...xs, 3)", 6},
+		{"let add = fn(a, b, c) { a + b + c }; add(0, ...[1], ...[2])", 3},
+		{`len(...["abc"])`, 3},
+		{"let f = fn(...r) { r }; f(...[], 1, ...[2, 3])", []int{1, 2, 3}},
+		{"let k = 5; let f = fn(x = k) { x }; f()", 5},
+		{"let mk = fn(d) { fn(x = d) { x } }; mk(7)()", 7},
+		{"let f = fn(a, g = fn() { a }) { g() }; f(4)", 4},
+		{"let sum = fn(xs, acc = 0) { if (len(xs) == 0) { return acc } sum(rest(xs), acc + first(xs)) }; sum([1, 2, 3])", 6},
+		{"let f = fn(a, b = 1) { let c = 2; a + b + c }; f(1) + f(1, 1)", 8},
+		{"let x = 1; let f = fn(a = x) { let x = 5; a }; f() + 1", 2},
+		{"let g = fn(y) { fn(a = y) { let y = 5; a + y } }; g(3)()", 8},
+		{"let b = 7; let f = fn(a = b, b = 1) { [a, b] }; f()", []int{7, 1}},
+		{"let f = fn(a, b = 2, c = a + b) { let a = 0; c }; f(1)", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestParameterErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn(a, b = 1) { a }()", "wrong number of args: want=1 to 2, got=0"},
+		{"fn(a, b = 1) { a }(1, 2, 3)", "wrong number of args: want=1 to 2, got=3"},
+		{"fn(a, ...r) { a }()", "wrong number of args: want at least 1, got=0"},
+		{"fn(a, b) { a }(...[1, 2, 3])", "wrong number of args: want=2, got=3"},
+		{"fn(a) { a }(...5)", "cannot spread INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn add(a, b) { a + b } add(1, 2)", 3},
+		{"let x = f(2); fn f(n) { n * 10 } x", 20},
+		{"fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } } fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } } isEven(10)", true},
+		{"isOdd(7); fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }; fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }; isOdd(7)", true},
+		{"let f = fn(n) { fn even(n) { if (n == 0) { return true } odd(n - 1) } fn odd(n) { if (n == 0) { return false } even(n - 1) } odd(n) }; f(7)", true},
+		{"fn fact(n) { if (n < 2) { return 1 } n * fact(n - 1) } fact(5)", 120},
+		{"let mk = fn(k) { fn add(x) { x + k } add }; mk(3)(4)", 7},
+		{`fn greet(name = "you") { "hi " + name } greet()`, "hi you"},
+		{"if (true) { fn g() { 5 } g() }", 5},
+		{"let f = fn() { let r = g(); fn g() { 8 } r }; f()", 8},
+		{"let y = 1; fn f() { y } f()", 1},
+		{"fn f() { y } let y = 2; f()", 2},
+		{"let a = 1; fn inc() { a += 1; b = a * 10 } let b = 0; inc(); [a, b]", []int{2, 20}},
+		{"let g = fn() { let v = 10; fn h() { v + w } let w = 5; h() }; g()", 15},
+		{"let g = fn() { fn h() { n += 1 } let n = 1; h(); n }; g()", 2},
+		{"let x = 1; let f = fn() { let y = x; let x = 5; y + x }; f()", 6},
+		{"f(); fn f() { 42 }", 42},
+		{"fn f() { 1 }", object.ObjectType(object.CLOSURE_OBJ)},
+		{"fn f() { 1 } fn g() { 2 }", object.ObjectType(object.CLOSURE_OBJ)},
+		{"1 + 1; fn f() {} fn g() {}", 2},
+		{"[if (true) { 1; fn g() {} }][0]", Null},
+		{"[fn() { 1; fn g() {} }()][0]", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionStatementErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn f() { z } f(); let z = 1", "variable used before it is set"},
+		{"let g = fn() { fn h() { n } h(); let n = 1 }; g()", "variable used before it is set"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (3) { 1 => "one", _ => "other" }`, "other"},
+		{`match (1.5) { 1 => "int", 1.5 => "float" }`, "float"},
+		{`match (1) { 1.0 => "float", 1 => "int" }`, "float"},
+		{`match (1.0) { 1 => "int", _ => "other" }`, "int"},
+		{`match (2.5) { 2 => "int", _ => "other" }`, "other"},
+		{`match (-2) { 2 => "pos", -2 => "neg" }`, "neg"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (false) { true => 1, false => 2 }`, 2},
+		{`match (null) { 0 => 1, null => 2 }`, 2},
+		{`match (5) { 1 => 1 }`, Null},
+		{`match (5) { n => n * 2 }`, 10},
+		{`match ([1, 2]) { [a] => a, [a, b, c] => a, [a, b] => a + b }`, 3},
+		{`match ([1, [2, 3]]) { [a, [b, 4]] => 0, [a, [b, c]] => a + b + c }`, 6},
+		{`match ([1, 2, 3]) { [h, ...t] => t }`, []int{2, 3}},
+		{`match ([]) { [h, ...t] => h, [] => "empty" }`, "empty"},
+		{`match ("abc") { [a] => 1, {a} => 2, _ => 3 }`, 3},
+		{`match ({"kind": "a", "v": 4}) { {kind: "b"} => 1, {kind: "a", v} if v > 5 => 2, {kind: "a", v} => v * 10 }`, 40},
+		{`match ({"kind": "b"}) { {kind: "a"} => 1, {kind: "b", extra} => 2, {kind} => kind }`, "b"},
+		{`let f = fn(x) { match (x) { n if n < 0 => { -n } n => { n } } }; [f(-3), f(4)]`, []int{3, 4}},
+		{`let f = fn(x) { match (x) { [h, ...t] => f(t) + h, _ => 0 } }; f([1, 2, 3])`, 6},
+		{`let x = 0; for (i in [1, 2, 3]) { match (i) { 2 => { break } _ => { x += i } } } x`, 1},
+		{`let route = fn(m) { match (m) { {type: "ping"} => "pong", {type: "add", args: [a, b]} => a + b, _ => "unknown" } }; route({"type": "add", "args": [1, 2]})`, 3},
+		{`let route = fn(m) { match (m) { {type: "ping"} => "pong", {type: "add", args: [a, b]} => a + b, _ => "unknown" } }; route({"type": "ping"}) + route({"type": "add", "args": [1]})`, "pongunknown"},
+		{`let x = 100; match (5) { x => x } + x`, 105},
+		{`let x = 100; match (5) { x => x }; x`, 100},
+		{`let f = fn(x) { let r = match (x + 1) { x => x * 10 }; r + x }; f(1)`, 21},
+		{`let g = fn(v) { match (v) { [n] => fn() { n * 2 } } }; g([4])()`, 8},
+		{`let t = 0; match (2) { n => t = n }; t`, 2},
+		{`let g = fn() { let t = 0; let h = match (1) { n => fn() { t += n } }; h(); h(); t }; g()`, 2},
+		{`match ([1]) { [a] if a > 1 => a, [b] => b + 1 }`, 2},
+		{`match (1) { 1 => ({"kind": "a"}) }["kind"]`, "a"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestPipe(t *testing.T) {
+	tests := []vmTestCase{
+		{"let add = fn(a, b) { a + b }; 1 |> add(2) |> add(3)", 6},
+		{"[1, 2, 3] |> push(4) |> len", 4},
+		{"let double = fn(xs) { let out = []; for (x in xs) { out = push(out, x * 2) } out }; [1, 2, 3] |> double |> rest", []int{4, 6}},
+		{"5 |> fn(x) { x * x }", 25},
+		{"let tail = fn(x, ...r) { r }; 1 |> tail(...[2, 3])", []int{2, 3}},
+		{"let inc = fn(x) { x + 1 }; 1 + 1 |> inc", 3},
+		{"let mk = fn(n) { fn(x) { x * n } }; 1 + 1 |> (mk(10))", 20},
+		{"[] |> len == 0", true},
+		{"[1, 2] |> len > 1", true},
+		{"let inc = fn(x) { x + 1 }; 1 |> inc + 1", 3},
+		{"let f = fn(x, n) { [x, x * n] }; 2 |> f(3)[1]", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMembers(t *testing.T) {
+	tests := []vmTestCase{
+		{`let h = {"name": "ann", "age": 3}; h.name`, "ann"},
+		{`let h = {"a": {"b": [1, 2]}}; h.a.b[1]`, 2},
+		{`let h = {}; h.missing`, Null},
+		{`let h = {}; h.name = "bo"; h.name`, "bo"},
+		{`let h = {"n": 1}; h.n += 2; h.n`, 3},
+		{`let h = {"len": 4}; h.len`, 4},
+		{`let h = {"f": fn(x) { x * 2 }}; h.f(4)`, 8},
+		{`"abc".upper()`, "ABC"},
+		{`" Hi ".trim().lower()`, "hi"},
+		{`"a,b,c".split(",").len()`, 3},
+		{`if ("hello".contains("ell")) { 1 } else { 2 }`, 1},
+		{`"hello".contains("x") == false`, true},
+		{`[1, 2].push(3)`, []int{1, 2, 3}},
+		{`[1, 2, 3].rest().first()`, 2},
+		{`[1, "a", true].join("-")`, "1-a-true"},
+		{`{"b": 2, "a": 1}.keys().join(" ")`, "a b"},
+		{`{"b": 2, "a": 1}.values()`, []int{1, 2}},
+		{`let h = {"a": 1}; h.has("a") && !h.has("b")`, true},
+		{`let up = "abc".upper; up()`, "ABC"},
+		{`"x" |> "abc".contains`, false},
+		{`"a-b".split(...["-"]).len()`, 2},
+		{`"abc".split(1)`, &object.Error{Message: "argument to `split` must be STRING, got=INTEGER"}},
+		{`"abc".upper(1)`, &object.Error{Message: "wrong number of args: got=1, want=0"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMemberErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`"abc".nope()`, "undefined method nope for STRING"},
+		{`5.len()`, "undefined method len for INTEGER"},
+		{`5.foo`, "undefined method foo for INTEGER"},
+		{`let m = "abc".nope; 1`, "undefined method nope for STRING"},
+		{`let h = {"a": 1}; h.a()`, "calling non-function and non-built-in *object.Integer"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	tests := []vmTestCase{
+		{`let count = 100; let c = import("./counter.is"); c.next(); c.next(); count`, 100},
+		{`let c = import("./counter.is"); c.next(); import("./counter.is").next()`, 2},
+		{`let p = import("./pair.is"); p.a + p.b`, 3},
+		{`import("./pair.is")["b"]`, 2},
+		{`import("./hoist.is").v`, 4},
+		{`let b = import("./bump.is"); b.bump(); b.bump()`, 4},
+		{`let m = import("./lib/math.is"); m.double(m.tau)`, 12},
+		{`fn load() { import("./lib/consts.is") } load().pi`, 3},
+		{`let m = import("./snapshot.is"); m.inc(); m.inc()`, 2},
+		{`let m = import("./snapshot.is"); m.inc(); m.n`, 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+// TestImportsAcrossLines runs each input the way the REPL runs a line, sharing
+// the state of the lines before it.
+func TestImportsAcrossLines(t *testing.T) {
+	lines := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let c = import("./counter.is"); c.next()`, 1},
+		{`import("./counter.is").next()`, 2},
+		{`c.next()`, 3},
+	}
+
+	symTable := compiler.NewSymTable()
+	for i, v := range object.Builtins {
+		symTable.DefineBuiltin(i, v.Name)
+	}
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalSize)
+	modules := compiler.NewModules(testModules, nil)
+	ran := Modules{}
+
+	for _, tt := range lines {
+		program, err := parse(tt.input)
+		if err != nil {
+			t.Fatalf("parser error: %s", err)
+		}
+
+		comp := compiler.NewWithState(symTable, constants)
+		comp.SetModules(modules)
+		err = comp.Compile(program)
+		if err != nil {
+			t.Fatalf("%s: compiler error: %s", tt.input, err)
+		}
+		constants = comp.Bytecode().Constants
+
+		vm := NewWithGlobalsStore(comp.Bytecode(), globals)
+		vm.SetModules(ran)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("%s: vm error: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestRegistryImports(t *testing.T) {
+	tests := []vmTestCase{
+		{`import("strings").repeat("ab", 2)`, "abab"},
+		{`let s = import("strings"); "x" |> s.repeat(3)`, "xxx"},
+		{`import("./lib/db.is").table`, "users"},
+		{`import("myapp/db")["name"]`, "user"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`import("strings").trim`, "module strings has no export trim"},
+		{`import("./pair.is").c`, "module pair.is has no export c"},
+		{`import("./counter.is").count`, "module counter.is has no export count"},
+		{`import("./pair.is")[1]`, "index operator not supported: MODULE"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestBuiltinFunc(t *testing.T) {
 	tests := []vmTestCase{
 		{`len("")`, 0},